@@ -0,0 +1,43 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+// BorderWaveform is the value of the border waveform control register,
+// selecting how the one pixel border around the active area is driven.
+//
+// Bits 7-6 select the source: 00 follows a LUT transition, 01 a fixed level,
+// 10 VCOM and 11 leaves the border floating. Bits 5-4 are the fixed level and
+// bits 1-0 the LUT used for transitions.
+type BorderWaveform byte
+
+// Common border waveforms.
+const (
+	// BorderWhite follows the LUT1 transition, that ends white. This is the
+	// default.
+	BorderWhite BorderWaveform = 0x01
+	// BorderBlack holds the border at VSH1, black.
+	BorderBlack BorderWaveform = 0x50
+	// BorderVCOM holds the border at VCOM, keeping what was shown before.
+	BorderVCOM BorderWaveform = 0x80
+	// BorderFloating leaves the border at high impedance.
+	BorderFloating BorderWaveform = 0xC0
+)
+
+// SetBorderWaveform sets how the border is driven on subsequent refreshes.
+// BorderVCOM avoids the border flashing on partial refreshes.
+//
+// The setting is kept across Init and refresh mode changes.
+func (d *Dev) SetBorderWaveform(b BorderWaveform) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(borderWaveformControl, byte(b)); err != nil {
+		return err
+	}
+	d.border = b
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"fmt"
+)
+
+// Config describes the panel attached to the controller.
+type Config struct {
+	// Width is the number of visible source lines, i.e. pixels per row.
+	Width int
+	// Height is the number of gate lines, i.e. rows.
+	Height int
+	// Controller is the display controller of the panel.
+	Controller Controller
+}
+
+// Controller is a display controller supported by this package.
+type Controller int
+
+// Supported controllers.
+const (
+	// SSD1675B is the controller of the 2.13inch v2 panels.
+	SSD1675B Controller = iota
+	// SSD1680 is the controller of the 2.13inch v3 panels. Its command set is
+	// nearly identical, but the waveform LUTs have a different format: the
+	// fast, custom and gray level waveforms are not supported, and partial
+	// refreshes use the waveform stored in OTP.
+	SSD1680
+)
+
+// EPD2in13V2 is the Waveshare 2.13inch e-Paper v2 panel. It is the default
+// for NewSPI and NewSPIHat.
+var EPD2in13V2 = Config{Width: 122, Height: 250}
+
+// EPD2in13V3 is the Waveshare 2.13inch e-Paper v3 panel, the current revision
+// of the HAT. It has the same resolution as the v2 one, with a SSD1680
+// controller; use it with NewSPIConfig.
+var EPD2in13V3 = Config{Width: 122, Height: 250, Controller: SSD1680}
+
+// stride returns the number of RAM bytes per row. The RAM is addressed in
+// whole bytes, so its width is rounded up to a multiple of 8 pixels; the
+// padding columns are not visible.
+func (c *Config) stride() int {
+	return (c.Width + 7) / 8
+}
+
+func (c *Config) validate() error {
+	// The RAM X address is a byte of 8 pixels, the gate count is 9 bits.
+	if c.Width <= 0 || c.Width > 256*8 || c.Height <= 0 || c.Height > 512 {
+		return fmt.Errorf("waveshare213v2: invalid panel size %dx%d", c.Width, c.Height)
+	}
+	if c.Controller != SSD1675B && c.Controller != SSD1680 {
+		return fmt.Errorf("waveshare213v2: unknown controller %d", int(c.Controller))
+	}
+	return nil
+}
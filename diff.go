@@ -0,0 +1,180 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"math/bits"
+	"time"
+)
+
+// DrawDiff is like Draw, but only writes and refreshes the region that
+// differs from the image currently displayed.
+//
+// The smallest rectangle enclosing the changed pixels is computed against
+// the last refreshed frame. Nothing is sent if nothing changed. If the
+// rectangle covers more than half of the panel, the whole frame is written
+// and refreshed with a full refresh instead, as with Refresh, which also
+// clears ghosting. Otherwise the display is refreshed with the current
+// refresh mode, so load the partial waveform with LoadPartialMode to get a
+// partial refresh.
+//
+// On tri-color panels the whole frame is always written.
+func (d *Dev) DrawDiff(dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	return d.drawDiff(context.Background(), dstRect, src, sp)
+}
+
+// drawDiff is DrawDiff with d.mu held, waiting for the refresh until ctx is
+// done.
+func (d *Dev) drawDiff(ctx context.Context, dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() {
+		return nil
+	}
+	if err := checkSource(src, r, sp); err != nil {
+		return err
+	}
+	d.drawBuffer(r, image.White, image.Point{})
+	d.drawBuffer(r, src, sp)
+	if d.red != nil {
+		return d.refresh(ctx)
+	}
+
+	dirty := d.dirtyRect()
+	if dirty.Empty() {
+		return nil
+	}
+	pr := d.ramRect(dirty)
+	if d.largeRect(pr) {
+		return d.refreshCurrent(ctx)
+	}
+	if _, err := d.writeRAM(writeRAMBW, d.buffer, pr, d.inverted); err != nil {
+		return err
+	}
+	if err := d.update(ctx); err != nil {
+		return err
+	}
+	draw.Draw(d.shown, pr, d.buffer, pr.Min, draw.Src)
+	d.dirty = image.Rectangle{}
+	return nil
+}
+
+// dirtyRect returns the smallest rectangle, in panel coordinates, enclosing
+// the pixels that differ between the frame buffer and the displayed image.
+func (d *Dev) dirtyRect() image.Rectangle {
+	var dirty image.Rectangle
+	w, h := d.cfg.Width, d.cfg.Height
+	stride := d.buffer.Stride
+	for i, b := range d.buffer.Pix {
+		diff := b ^ d.shown.Pix[i]
+		if diff == 0 {
+			continue
+		}
+		x, y := i%stride, i/stride*8
+		if x >= w {
+			continue
+		}
+		y0 := y + bits.TrailingZeros8(diff)
+		y1 := y + 8 - bits.LeadingZeros8(diff)
+		if y1 > h {
+			y1 = h
+		}
+		dirty = dirty.Union(image.Rect(x, y0, x+1, y1))
+	}
+	return dirty
+}
+
+var errDifferentialTriColor = errors.New("waveshare213v2: differential partial refreshes require a black and white panel")
+
+// DrawDifferentialPartial draws src into dstRect as Draw does, then shows the
+// frame with a two stage partial refresh, as done by the GxEPD2 drivers: the
+// image currently displayed is written to the second RAM as the old image,
+// the frame buffer to the first as the new one, and the display is refreshed
+// with the partial waveform in display mode 2.
+//
+// Since the old image always matches the display, only the changed pixels
+// are driven, with less ghosting than repeated DrawPartial calls. The whole
+// frame is written. The partial waveform is loaded first if needed, leaving
+// the refresh mode in RefreshPartial, and the controller is powered off when
+// done.
+//
+// It returns an error on tri-color panels, whose second RAM is the red plane.
+func (d *Dev) DrawDifferentialPartial(dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	if src == nil {
+		return errNilSource
+	}
+	if d.red != nil {
+		return errDifferentialTriColor
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() {
+		return nil
+	}
+	if err := checkSource(src, r, sp); err != nil {
+		return err
+	}
+	d.drawBuffer(r, image.White, image.Point{})
+	d.drawBuffer(r, src, sp)
+
+	if d.mode != RefreshPartial {
+		if err := d.loadWaveform(RefreshPartial); err != nil {
+			return err
+		}
+	}
+	ctx := context.Background()
+	if err := d.throttle(ctx); err != nil {
+		return err
+	}
+	if _, err := d.writeRAM(writeRAMRed, d.shown, d.shown.Bounds(), d.inverted); err != nil {
+		return err
+	}
+	if _, err := d.writeRAM(writeRAMBW, d.buffer, d.buffer.Bounds(), d.inverted); err != nil {
+		return err
+	}
+	// Enable clock and analog, display mode 2, disable analog and clock.
+	option := byte(0xCF)
+	if d.cfg.Controller == SSD1680 {
+		// The same, loading the partial waveform from OTP.
+		option = 0xFF
+		if d.tempOverride != nil {
+			option &^= 0x20
+		}
+	}
+	if err := d.sendCommand(displayUpdateControl2, option); err != nil {
+		return err
+	}
+	if err := d.sendCommand(masterActivation); err != nil {
+		return err
+	}
+	d.lastRefresh = time.Now()
+	if err := d.waitUntilIdle(ctx, "differential partial update", d.updateDelay(RefreshPartial)); err != nil {
+		return err
+	}
+	d.refreshed()
+	d.poweredOff = true
+	copy(d.shown.Pix, d.buffer.Pix)
+	d.dirty = image.Rectangle{}
+	return nil
+}
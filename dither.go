@@ -0,0 +1,120 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// Dither is the algorithm used to reduce an image to one bit.
+type Dither int
+
+// Supported dithering algorithms.
+const (
+	// FloydSteinberg diffuses the quantization error to neighboring pixels.
+	FloydSteinberg Dither = iota
+	// NoDither thresholds each pixel independently.
+	NoDither
+)
+
+// DitherOpts are the options for DrawDithered.
+type DitherOpts struct {
+	Algorithm Dither
+	// Threshold is the luminance, in (0, 1], from which a pixel is white.
+	// Zero means 0.5.
+	Threshold float32
+	// Gamma is applied to the luminance of src before dithering: values above
+	// 1 darken midtones, values below 1 lighten them. Zero means 1, i.e. the
+	// luminance is used as is.
+	Gamma float64
+}
+
+// DrawDithered draws src, aligned to the top left corner of the display, with
+// dithering and refreshes the display. It gives much better results than Draw
+// for photos and gradients.
+//
+// opts may be nil, in which case Floyd-Steinberg dithering is used.
+func (d *Dev) DrawDithered(src image.Image, opts *DitherOpts) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if opts == nil {
+		opts = &DitherOpts{}
+	}
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = 0.5
+	}
+	b := d.bounds()
+	w, h := b.Dx(), b.Dy()
+	sb := src.Bounds()
+	// Luminance of each pixel, in [0, 1]; white outside of src.
+	lum := make([]float32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			l := float32(1)
+			if p := image.Pt(sb.Min.X+x, sb.Min.Y+y); p.In(sb) {
+				l = luminance(src.At(p.X, p.Y))
+				if opts.Gamma != 0 && opts.Gamma != 1 {
+					l = float32(math.Pow(float64(l), opts.Gamma))
+				}
+			}
+			lum[y*w+x] = l
+		}
+	}
+
+	v := &view{d, d.buffer}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			old := lum[y*w+x]
+			bit := image1bit.Bit(old >= threshold)
+			v.SetBit(b.Min.X+x, b.Min.Y+y, bit)
+			if opts.Algorithm != FloydSteinberg {
+				continue
+			}
+			e := old
+			if bit {
+				e = old - 1
+			}
+			if x+1 < w {
+				lum[y*w+x+1] += e * 7 / 16
+			}
+			if y+1 < h {
+				if x > 0 {
+					lum[(y+1)*w+x-1] += e * 3 / 16
+				}
+				lum[(y+1)*w+x] += e * 5 / 16
+				if x+1 < w {
+					lum[(y+1)*w+x+1] += e * 1 / 16
+				}
+			}
+		}
+	}
+	if d.red != nil {
+		draw.Draw(d.red, d.red.Bounds(), &image.Uniform{image1bit.Off}, image.Point{}, draw.Src)
+	}
+	return d.refresh(context.Background())
+}
+
+// luminance returns the luminance of c composited over white, in [0, 1].
+func luminance(c color.Color) float32 {
+	_, _, _, a := c.RGBA()
+	y := color.Gray16Model.Convert(c).(color.Gray16).Y
+	// The components are alpha-premultiplied; add the white background.
+	return float32(uint32(y)+0xFFFF-a) / 0xFFFF
+}
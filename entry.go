@@ -0,0 +1,53 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"fmt"
+)
+
+// DataEntryMode is the direction the RAM address counters move in as data is
+// written.
+//
+// Since the driver always writes the panel from its top left pixel, changing
+// the direction mirrors the image in hardware: decrementing X mirrors it
+// horizontally and incrementing Y mirrors it vertically, relative to the
+// default DataEntryXIncYDec. The 122 pixel wide image stays aligned on the
+// visible RAM columns.
+type DataEntryMode byte
+
+// Supported data entry modes.
+const (
+	DataEntryXDecYDec DataEntryMode = 0x00
+	DataEntryXIncYDec DataEntryMode = 0x01
+	DataEntryXDecYInc DataEntryMode = 0x02
+	DataEntryXIncYInc DataEntryMode = 0x03
+)
+
+const (
+	entryXInc DataEntryMode = 0x01
+	entryYInc DataEntryMode = 0x02
+)
+
+// SetDataEntryMode sets the RAM address counter directions. The default is
+// DataEntryXIncYDec.
+//
+// The frame buffer isn't written again; call Refresh to show it in the new
+// orientation.
+func (d *Dev) SetDataEntryMode(m DataEntryMode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if m > DataEntryXIncYInc {
+		return fmt.Errorf("waveshare213v2: invalid data entry mode 0x%02X", byte(m))
+	}
+	if err := d.sendCommand(dataEntryModeSetting, byte(m)); err != nil {
+		return err
+	}
+	d.entry = m
+	return d.setWindow(d.ramWindow(d.panelBounds()))
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"image"
+	"time"
+)
+
+// PlayFrames shows frames in turn, each aligned to the top left corner of the
+// display, waiting delay between them, e.g. for a slideshow or a simple
+// animation.
+//
+// Each frame is drawn with DrawDiff, so only what changed since the previous
+// one is written; load the partial or fast waveform with SetRefreshMode for
+// quicker transitions. Refreshes are still spaced by at least
+// MinRefreshInterval. PlayFrames returns ctx.Err() as soon as ctx is done,
+// while waiting between frames or for a refresh to complete, leaving the
+// refresh in progress to complete on the panel.
+func (d *Dev) PlayFrames(ctx context.Context, frames []image.Image, delay time.Duration) error {
+	for i, f := range frames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f == nil {
+			return errNilSource
+		}
+		if err := d.playFrame(ctx, f); err != nil {
+			return err
+		}
+		if i == len(frames)-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return nil
+}
+
+// playFrame draws f as DrawDiff, waiting for the refresh until ctx is done.
+func (d *Dev) playFrame(ctx context.Context, f image.Image) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	return d.drawDiff(ctx, d.bounds(), f, f.Bounds().Min)
+}
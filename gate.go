@@ -0,0 +1,84 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"fmt"
+)
+
+// Gate scan options of SetGateConfig.
+const (
+	// GateScanReverse scans the gates from the last to the first, flipping the
+	// image along the panel's long side, e.g. for panels mounted upside down.
+	GateScanReverse byte = 0x01
+	// GateScanInterlaced scans the even gates, then the odd ones.
+	GateScanInterlaced byte = 0x02
+	// GateScanSwapFirst swaps the first gate output between the two sides of
+	// the panel.
+	GateScanSwapFirst byte = 0x04
+)
+
+// gateConfig is the gate configuration set by SetGateConfig; the zero value
+// drives all gates from the first one.
+type gateConfig struct {
+	lines int
+	first int
+	scan  byte
+}
+
+// SetGateConfig sets the gate lines driven by the controller: lines gate
+// lines from firstLine, scanned as selected by scanDir, a combination of
+// GateScanReverse, GateScanInterlaced and GateScanSwapFirst. The panel default
+// drives all Height lines from the first one with scanDir 0.
+//
+// Fewer lines limit the active area, the rows of the frame buffer after the
+// last line being ignored. firstLine plus lines must not exceed Height. The
+// setting is kept across Init.
+func (d *Dev) SetGateConfig(lines, firstLine int, scanDir byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if lines < 1 || firstLine < 0 || firstLine+lines > d.cfg.Height {
+		return fmt.Errorf("waveshare213v2: %d gate lines from %d exceed the %d lines of the panel", lines, firstLine, d.cfg.Height)
+	}
+	if scanDir&^(GateScanReverse|GateScanInterlaced|GateScanSwapFirst) != 0 {
+		return fmt.Errorf("waveshare213v2: invalid gate scan option 0x%02X", scanDir)
+	}
+	g := gateConfig{lines: lines, first: firstLine, scan: scanDir}
+	cmds := g.initCommands(d.cfg.Height)
+	if g.first == 0 && d.gates.first != 0 {
+		cmds = append(cmds, initCommand{cmd: gateScanStartPosition, data: []byte{0, 0}})
+	}
+	for _, c := range cmds {
+		if err := d.sendCommand(c.cmd, c.data...); err != nil {
+			return err
+		}
+	}
+	d.gates = g
+	return nil
+}
+
+// gateInit returns the commands configuring the gates in Init.
+func (d *Dev) gateInit() []initCommand {
+	return d.gates.initCommands(d.cfg.Height)
+}
+
+// initCommands returns the commands setting g on a panel of height lines.
+// The gate scan start position is only sent when it differs from its reset
+// value.
+func (g gateConfig) initCommands(height int) []initCommand {
+	lines := g.lines
+	if lines == 0 {
+		lines = height
+	}
+	mux := lines - 1
+	cmds := []initCommand{{cmd: driverOutputControl, data: []byte{byte(mux), byte(mux >> 8), g.scan}}}
+	if g.first != 0 {
+		cmds = append(cmds, initCommand{cmd: gateScanStartPosition, data: []byte{byte(g.first), byte(g.first >> 8)}})
+	}
+	return cmds
+}
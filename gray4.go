@@ -0,0 +1,129 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"errors"
+	"image"
+	"time"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// Gray4Level is the pair of RAM bits that selects the waveform used to drive
+// a pixel in 4-level grayscale mode.
+type Gray4Level struct {
+	BW  image1bit.Bit // Bit written to the black/white RAM (0x24).
+	Red image1bit.Bit // Bit written to the second RAM (0x26).
+}
+
+// Gray4Mapping maps the four gray levels, from black (0) to white (3), to
+// their RAM bits.
+type Gray4Mapping [4]Gray4Level
+
+// DefaultGray4Mapping matches the waveform in lutGray4. The controller picks
+// LUT0 to LUT3 from the (Red, BW) bit pair, so black uses LUT0, dark gray
+// LUT2, light gray LUT1 and white LUT3.
+var DefaultGray4Mapping = Gray4Mapping{
+	{BW: image1bit.Off, Red: image1bit.Off},
+	{BW: image1bit.Off, Red: image1bit.On},
+	{BW: image1bit.On, Red: image1bit.Off},
+	{BW: image1bit.On, Red: image1bit.On},
+}
+
+// lutGray4 first cleans every pixel by driving it black then white, then
+// drives it back towards black for a duration depending on its level.
+var lutGray4 = []byte{
+	0x60, 0x54, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT0: black
+	0x60, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT1: light gray
+	0x60, 0x50, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT2: dark gray
+	0x60, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT3: white
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT4: VCOM
+	0x0F, 0x0F, 0x00, 0x00, 0x00, // TP0 A-D RP0
+	0x06, 0x03, 0x02, 0x00, 0x00, // TP1 A-D RP1
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP2 A-D RP2
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP3 A-D RP3
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP4 A-D RP4
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP5 A-D RP5
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP6 A-D RP6
+}
+
+// DrawGray4 draws src, aligned to the top left corner of the display, in 4
+// gray levels and refreshes the display.
+//
+// The luminance of each pixel is quantized to four levels, which are written
+// to both RAM planes according to d.Gray4. The refresh takes noticeably longer
+// than a full update and the exact shades depend on the panel and
+// temperature. The refresh mode is reset to RefreshFull. Tri-color panels are
+// not supported.
+//
+// The frame buffer is replaced by the black and white plane, which
+// approximates the image shown, e.g. for Snapshot and DrawDiff.
+func (d *Dev) DrawGray4(src image.Image) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if d.red != nil {
+		return errors.New("waveshare213v2: grayscale is not supported on tri-color panels")
+	}
+	if d.cfg.Controller != SSD1675B {
+		return errNoCustomLUT
+	}
+	bw := image1bit.NewVerticalLSB(d.panelBounds())
+	red := image1bit.NewVerticalLSB(d.panelBounds())
+	bwv, redv := &view{d, bw}, &view{d, red}
+	b := d.bounds()
+	sb := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			l := 3
+			if p := image.Pt(sb.Min.X+x, sb.Min.Y+y); p.In(sb) {
+				l = int(luminance(src.At(p.X, p.Y))*255) >> 6
+			}
+			bwv.SetBit(x, y, d.Gray4[l].BW)
+			redv.SetBit(x, y, d.Gray4[l].Red)
+		}
+	}
+
+	if _, err := d.writeRAM(writeRAMBW, bw, bw.Bounds(), false); err != nil {
+		return err
+	}
+	if _, err := d.writeRAM(writeRAMRed, red, red.Bounds(), false); err != nil {
+		return err
+	}
+	if err := d.sendCommand(writeLUTRegister, lutGray4...); err != nil {
+		return err
+	}
+	d.mode = RefreshFull
+	if err := d.throttle(context.Background()); err != nil {
+		return err
+	}
+	// Same sequence as a full update, without reloading the LUT from OTP.
+	if err := d.sendCommand(displayUpdateControl2, 0xC7); err != nil {
+		return err
+	}
+	if err := d.sendCommand(masterActivation); err != nil {
+		return err
+	}
+	d.lastRefresh = time.Now()
+	if err := d.waitUntilIdle(context.Background(), "grayscale update", d.updateDelay(RefreshFull)+time.Second); err != nil {
+		return err
+	}
+	d.refreshed()
+	d.poweredOff = true
+	// Approximate the displayed image with its black/white plane.
+	copy(d.buffer.Pix, bw.Pix)
+	copy(d.shown.Pix, bw.Pix)
+	d.dirty = image.Rectangle{}
+	return nil
+}
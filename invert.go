@@ -0,0 +1,26 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+// SetInverted sets whether the black and white RAM content is inverted, i.e.
+// white is shown as black and vice versa, e.g. for a dark theme. The bits are
+// flipped as they are written, so the frame buffer, Snapshot and Image keep
+// the non inverted image.
+//
+// It takes effect on the next refresh and applies to Clear; Halt still
+// clears the display to white. The red plane of tri-color panels and
+// DrawGray4 are not affected.
+func (d *Dev) SetInverted(inverted bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inverted = inverted
+}
+
+// Inverted reports whether the display is inverted by SetInverted.
+func (d *Dev) Inverted() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inverted
+}
@@ -0,0 +1,319 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RefreshMode selects the waveform used by Update.
+type RefreshMode int
+
+// Supported refresh modes.
+const (
+	// RefreshFull uses the waveform stored in the controller OTP. It gives the
+	// best contrast and takes about 2 seconds.
+	RefreshFull RefreshMode = iota
+	// RefreshFast uses lutFastUpdate, a shortened full waveform. It refreshes
+	// in well under a second at the cost of some contrast. It is not
+	// supported by the SSD1680.
+	RefreshFast
+	// RefreshPartial uses lutPartialUpdate. It only drives changed pixels and
+	// doesn't flash, but ghosting builds up over time.
+	RefreshPartial
+)
+
+func (m RefreshMode) String() string {
+	switch m {
+	case RefreshFull:
+		return "full"
+	case RefreshFast:
+		return "fast"
+	case RefreshPartial:
+		return "partial"
+	default:
+		return fmt.Sprintf("RefreshMode(%d)", int(m))
+	}
+}
+
+// updateDelay returns how long to wait for a refresh in mode m when the busy
+// line isn't connected.
+func (d *Dev) updateDelay(m RefreshMode) time.Duration {
+	switch {
+	case d.red != nil:
+		return 20 * time.Second
+	case m == RefreshFast:
+		return time.Second
+	case m == RefreshPartial:
+		return 300 * time.Millisecond
+	default:
+		return 2500 * time.Millisecond
+	}
+}
+
+// updateOption returns the displayUpdateControl2 option byte used to refresh
+// the display in this mode.
+func (m RefreshMode) updateOption() byte {
+	switch m {
+	case RefreshFast:
+		// Enable clock and analog, display mode 1, disable analog and clock.
+		return 0xC7
+	case RefreshPartial:
+		// Display mode 2, clock and analog are left enabled.
+		return 0x0C
+	default:
+		// As RefreshFast, loading temperature and LUT from OTP first.
+		return 0xF7
+	}
+}
+
+// updateOption returns the option byte for a refresh in mode m. Full
+// refreshes use the option set by SetFullRefreshOption, if any. No refresh
+// measures the temperature when it is overridden.
+func (d *Dev) updateOption(m RefreshMode) byte {
+	o := m.updateOption()
+	if m == RefreshFull && d.fullOption != 0 {
+		o = d.fullOption
+	}
+	if m == RefreshPartial && d.cfg.Controller == SSD1680 {
+		// Load the LUT from OTP and use display mode 2, leaving clock and
+		// analog enabled.
+		o = 0xFC
+	}
+	if d.tempOverride != nil {
+		// Drop "load temperature".
+		o &^= 0x20
+	}
+	return o
+}
+
+// errNoCustomLUT is returned when loading a waveform of the SSD1675B format on
+// another controller.
+var errNoCustomLUT = errors.New("waveshare213v2: custom waveforms are only supported by the SSD1675B")
+
+// lutSize is the length of a waveform LUT: 5 voltage groups of 7 bytes and
+// 7 timing groups of 5 bytes.
+//
+// Each byte of a voltage group holds the source voltage of phases A to D of a
+// timing group, 2 bits each from the most significant: 00 is VSS, 01 VSH1,
+// 10 VSL and 11 VSH2. Each timing group gives the number of frames of phases
+// A to D, followed by the repeat count of the group.
+const lutSize = 70
+
+// lutPartialUpdate is the waveform used for partial refreshes, taken from the
+// Waveshare reference driver.
+//
+// Only pixels that differ between the two RAMs, the new image and the base
+// image, are driven: LUT1 to VSL and LUT2 to VSH1, for the 10 frames of phase
+// TP0A. Other pixels are held at VSS, so they don't flash.
+var lutPartialUpdate = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT0: BB: VS 0-7
+	0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT1: BW: VS 0-7
+	0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT2: WB: VS 0-7
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT3: WW: VS 0-7
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT4: VCOM: VS 0-7
+	0x0A, 0x00, 0x00, 0x00, 0x00, // TP0 A-D RP0
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP1 A-D RP1
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP2 A-D RP2
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP3 A-D RP3
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP4 A-D RP4
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP5 A-D RP5
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP6 A-D RP6
+}
+
+// lutFastUpdate is the Waveshare full update waveform with shorter phases.
+var lutFastUpdate = []byte{
+	0x80, 0x60, 0x40, 0x00, 0x00, 0x00, 0x00, // LUT0: BB: VS 0-7
+	0x10, 0x60, 0x20, 0x00, 0x00, 0x00, 0x00, // LUT1: BW: VS 0-7
+	0x80, 0x60, 0x40, 0x00, 0x00, 0x00, 0x00, // LUT2: WB: VS 0-7
+	0x10, 0x60, 0x20, 0x00, 0x00, 0x00, 0x00, // LUT3: WW: VS 0-7
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUT4: VCOM: VS 0-7
+	0x02, 0x02, 0x00, 0x00, 0x00, // TP0 A-D RP0
+	0x04, 0x04, 0x00, 0x00, 0x00, // TP1 A-D RP1
+	0x02, 0x02, 0x00, 0x00, 0x00, // TP2 A-D RP2
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP3 A-D RP3
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP4 A-D RP4
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP5 A-D RP5
+	0x00, 0x00, 0x00, 0x00, 0x00, // TP6 A-D RP6
+}
+
+// SetRefreshMode loads the waveform for m, used by subsequent updates.
+//
+// Switching back to RefreshFull re-initializes the controller, which
+// discards the RAM content but not the displayed image. Switching to
+// RefreshPartial writes the base image as described for LoadPartialMode.
+func (d *Dev) SetRefreshMode(m RefreshMode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.setRefreshMode(m)
+}
+
+// CurrentRefreshMode returns the refresh mode used by updates, as set by
+// SetRefreshMode, LoadPartialMode or LoadFullMode. It is RefreshFull after
+// Init and Reset, which load the waveform from OTP, and RefreshFast after
+// WriteLUT in RefreshFull mode.
+func (d *Dev) CurrentRefreshMode() RefreshMode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mode
+}
+
+// setRefreshMode is SetRefreshMode with d.mu held. Entering RefreshPartial
+// writes the displayed image to the second RAM as the base image, except on
+// tri-color panels where it holds the red plane.
+func (d *Dev) setRefreshMode(m RefreshMode) error {
+	if err := d.loadWaveform(m); err != nil {
+		return err
+	}
+	if m == RefreshPartial && d.red == nil {
+		if _, err := d.writeRAM(writeRAMRed, d.shown, d.shown.Bounds(), d.inverted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadWaveform loads the waveform of m and sets the refresh mode, leaving
+// the RAM untouched.
+func (d *Dev) loadWaveform(m RefreshMode) error {
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if d.cfg.Controller == SSD1680 && m != RefreshFull {
+		if m != RefreshPartial {
+			return errNoCustomLUT
+		}
+		d.mode = m
+		d.partials = 0
+		return nil
+	}
+	switch m {
+	case RefreshFull:
+		return d.initialize()
+	case RefreshFast:
+		if err := d.sendCommand(writeVCOMRegister, d.vcom(0x55)); err != nil {
+			return err
+		}
+		if err := d.sendCommand(writeLUTRegister, lutFastUpdate...); err != nil {
+			return err
+		}
+	case RefreshPartial:
+		if err := d.sendCommand(writeVCOMRegister, d.vcom(0x26)); err != nil {
+			return err
+		}
+		if err := d.waitUntilIdle(context.Background(), "VCOM setting", noBusyDelay); err != nil {
+			return err
+		}
+		if err := d.sendCommand(writeLUTRegister, lutPartialUpdate...); err != nil {
+			return err
+		}
+		if err := d.sendCommand(writeDisplayOptionRegister, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00); err != nil {
+			return err
+		}
+		// Clock and analog stay enabled for partial refreshes.
+		if err := d.powerOn(); err != nil {
+			return err
+		}
+		if err := d.sendCommand(borderWaveformControl, byte(d.border)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("waveshare213v2: unknown refresh mode %d", int(m))
+	}
+	d.mode = m
+	d.partials = 0
+	return nil
+}
+
+// fullUpdate performs a full refresh of the RAM content whatever the current
+// mode, which clears ghosting, then restores the waveform of the mode. In
+// RefreshPartial mode, the frame buffer becomes the base image, except on
+// tri-color panels.
+func (d *Dev) fullUpdate(ctx context.Context) error {
+	if err := d.throttle(ctx); err != nil {
+		return err
+	}
+	if err := d.sendCommand(displayUpdateControl2, d.updateOption(RefreshFull)); err != nil {
+		return err
+	}
+	if err := d.sendCommand(masterActivation); err != nil {
+		return err
+	}
+	d.lastRefresh = time.Now()
+	if err := d.waitUntilIdle(ctx, "full update", d.updateDelay(RefreshFull)); err != nil {
+		return err
+	}
+	d.refreshed()
+	d.poweredOff = true
+	switch d.mode {
+	case RefreshFast:
+		// The full update reloaded the LUT from OTP.
+		return d.loadWaveform(RefreshFast)
+	case RefreshPartial:
+		// The second RAM holds the red plane of tri-color panels instead of
+		// the base image.
+		if d.red == nil {
+			if _, err := d.writeRAM(writeRAMRed, d.buffer, d.buffer.Bounds(), d.inverted); err != nil {
+				return err
+			}
+		}
+		return d.loadWaveform(RefreshPartial)
+	}
+	return nil
+}
+
+// LoadPartialMode loads the partial refresh waveform used by DrawPartial.
+// It is a shorthand for SetRefreshMode(RefreshPartial).
+//
+// Besides lutPartialUpdate, it sets VCOM to 0x26, sets the display option
+// register (0x37) as in the Waveshare reference driver, enables clock and
+// analog, which partial refreshes leave enabled, and sends the border
+// waveform again.
+//
+// The image currently displayed is written to the second RAM as the base for
+// subsequent partial refreshes, except on tri-color panels. Init restores the
+// default full refresh waveform.
+func (d *Dev) LoadPartialMode() error {
+	return d.SetRefreshMode(RefreshPartial)
+}
+
+// LoadFullMode restores the full refresh waveform from OTP after
+// LoadPartialMode. It is a shorthand for SetRefreshMode(RefreshFull), which
+// re-initializes the controller; the frame buffer, the displayed image and
+// the settings kept across Init are preserved.
+func (d *Dev) LoadFullMode() error {
+	return d.SetRefreshMode(RefreshFull)
+}
+
+// WriteLUT loads a custom waveform, e.g. one ported from the GxEPD2 or
+// pwnagotchi drivers. lut must be exactly 70 bytes: the voltage selection of
+// LUT0 to LUT4 followed by the timing of the 7 phase groups.
+//
+// The table is used by subsequent updates until the next SetRefreshMode or
+// Init. In RefreshFull mode, the mode is changed to RefreshFast since full
+// updates reload the waveform from OTP.
+func (d *Dev) WriteLUT(lut []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if d.cfg.Controller == SSD1680 {
+		return errNoCustomLUT
+	}
+	if len(lut) != lutSize {
+		return fmt.Errorf("waveshare213v2: invalid LUT length %d, expected %d", len(lut), lutSize)
+	}
+	if err := d.sendCommand(writeLUTRegister, lut...); err != nil {
+		return err
+	}
+	if d.mode == RefreshFull {
+		d.mode = RefreshFast
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// previewRed is the gray level of red pixels in Preview.
+const previewRed = 0x80
+
+// Preview returns the image the panel shows once the frame buffer is
+// refreshed, in display coordinates, e.g. to check layouts without the
+// hardware.
+//
+// The frame buffer is encoded as it is written to RAM, so SetInverted and the
+// black and white RAM option of SetRAMOptions apply, then mapped to the
+// display with the current rotation and mirroring. Red pixels of tri-color
+// panels are mid gray.
+func (d *Dev) Preview() *image.Gray {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	panel := image1bit.NewVerticalLSB(d.panelBounds())
+	data := d.encodeRAM(d.buffer, d.buffer.Bounds(), d.inverted)
+	switch RAMOption(d.ramOptions & 0x0F) {
+	case RAMBypass:
+		data = make([]byte, len(data))
+	case RAMInverse:
+		for i := range data {
+			data[i] = ^data[i]
+		}
+	}
+	d.decodeRAM(panel, data, false)
+
+	b := d.bounds()
+	img := image.NewGray(b)
+	bw, red := &view{d, panel}, &view{d, d.red}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.Gray{}
+			if bw.BitAt(x, y) == image1bit.On {
+				c.Y = 0xFF
+			}
+			if d.red != nil && red.BitAt(x, y) == image1bit.On {
+				c.Y = previewRed
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	return img
+}
+
+// WritePreview encodes Preview as PNG to w.
+func (d *Dev) WritePreview(w io.Writer) error {
+	return png.Encode(w, d.Preview())
+}
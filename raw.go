@@ -0,0 +1,139 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+)
+
+// FrameSize returns the size in bytes of the RAM content of a frame, as
+// returned by EncodeImage and expected by DrawRaw.
+//
+// Each of the Height rows is stored in (Width+7)/8 bytes, the width being
+// padded to whole bytes: 16*250 = 4000 bytes for the 2.13inch panel, whose
+// 122 pixel rows are padded to 128.
+func (d *Dev) FrameSize() int {
+	return d.cfg.stride() * d.cfg.Height
+}
+
+// RAMBit returns where the pixel (x, y), in display coordinates, is stored in
+// a frame returned by EncodeImage or passed to DrawRaw: the index of its byte
+// and the mask of its bit, set for white unless SetInverted is on. ok is false
+// if the pixel is out of Bounds.
+//
+// Rotation, mirroring and the data entry mode apply. With the defaults, row y
+// of the 2.13inch panel is stored in bytes 16*y to 16*y+15, pixel 121 in the
+// most significant bit of the first byte and pixel 0 in bit 0x40 of the last
+// one, whose 6 lowest bits are padding:
+//
+//	i, mask, _ := d.RAMBit(0, 1) // i is 31, mask is 0x40.
+//	frame[i] |= mask             // Sets the pixel white.
+func (d *Dev) RAMBit(x, y int) (i int, mask byte, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := image.Pt(x, y)
+	if !p.In(d.bounds()) {
+		return 0, 0, false
+	}
+	i, mask = d.ramBit(d.toPanel(p))
+	return i, mask, true
+}
+
+// DrawRaw writes data, the black and white RAM content of the whole panel as
+// returned by EncodeImage, and refreshes the display with the current refresh
+// mode.
+//
+// data must be exactly FrameSize bytes, encoded for the current data entry
+// mode and inversion. The frame buffer is updated to match. The red RAM of
+// tri-color panels is left as is.
+func (d *Dev) DrawRaw(data []byte) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if n := d.FrameSize(); len(data) != n {
+		return fmt.Errorf("waveshare213v2: raw frame is %d bytes, expected %d", len(data), n)
+	}
+	err := d.retry(func() error {
+		if err := d.setWindow(d.ramWindow(d.panelBounds())); err != nil {
+			return err
+		}
+		if err := d.sendCommand(writeRAMBW); err != nil {
+			return err
+		}
+		if err := d.sendData(data...); err != nil {
+			return fmt.Errorf("waveshare213v2: writing %d bytes to RAM 0x%02X: %w", len(data), writeRAMBW, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.decodeRAM(d.buffer, data, d.inverted)
+	if err := d.update(context.Background()); err != nil {
+		return err
+	}
+	copy(d.shown.Pix, d.buffer.Pix)
+	d.dirty = image.Rectangle{}
+	return nil
+}
+
+// DrawFromReader reads a frame of exactly FrameSize bytes from r, as expected
+// by DrawRaw, and shows it with DrawRaw, e.g. from a file or a socket. The
+// frame is read before the display is accessed, so a slow reader doesn't
+// block other operations.
+//
+// If r ends before the frame is complete, the returned error wraps
+// io.ErrUnexpectedEOF and nothing is drawn.
+func (d *Dev) DrawFromReader(r io.Reader) error {
+	data := make([]byte, d.FrameSize())
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("waveshare213v2: reading raw frame: %w", err)
+	}
+	return d.DrawRaw(data)
+}
+
+// FrameWriter returns an io.Writer that shows each complete frame written to
+// it with DrawRaw, e.g. to pipe frames from a network connection. A frame is
+// FrameSize bytes, as returned by EncodeImage; writes may split frames or span
+// several of them, and an incomplete frame is kept until the rest of it is
+// written.
+//
+// The returned writer is not safe for concurrent use.
+func (d *Dev) FrameWriter() io.Writer {
+	return &frameWriter{d: d, buf: make([]byte, 0, d.FrameSize())}
+}
+
+type frameWriter struct {
+	d   *Dev
+	buf []byte
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) != 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+		if len(w.buf) == cap(w.buf) {
+			err := w.d.DrawRaw(w.buf)
+			w.buf = w.buf[:0]
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"image"
+	"image/color"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// Rotation is the clockwise rotation of the image on the panel.
+type Rotation int
+
+// Supported rotations.
+const (
+	Rotate0 Rotation = iota
+	Rotate90
+	Rotate180
+	Rotate270
+)
+
+// SetRotation sets the rotation applied to subsequent draws. Bounds reports
+// swapped dimensions for Rotate90 and Rotate270, so images sized from Bounds
+// cover the whole panel in any rotation.
+//
+// The frame buffer is kept in panel coordinates: what was already drawn stays
+// where it is on the panel.
+func (d *Dev) SetRotation(r Rotation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rotation = r & 3
+}
+
+// Rotation returns the current rotation.
+func (d *Dev) Rotation() Rotation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rotation
+}
+
+// SetMirror mirrors subsequent draws horizontally and/or vertically. The
+// mirroring applies to the image as seen by the caller, before rotation.
+func (d *Dev) SetMirror(x, y bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mirrorX = x
+	d.mirrorY = y
+}
+
+// Mirror returns the current horizontal and vertical mirroring.
+func (d *Dev) Mirror() (x, y bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mirrorX, d.mirrorY
+}
+
+// toPanel converts a point in display coordinates, as seen by callers, to
+// panel coordinates.
+func (d *Dev) toPanel(p image.Point) image.Point {
+	if d.mirrorX || d.mirrorY {
+		b := d.bounds()
+		if d.mirrorX {
+			p.X = b.Max.X - 1 - p.X
+		}
+		if d.mirrorY {
+			p.Y = b.Max.Y - 1 - p.Y
+		}
+	}
+	w, h := d.cfg.Width, d.cfg.Height
+	switch d.rotation {
+	case Rotate90:
+		return image.Pt(w-1-p.Y, p.X)
+	case Rotate180:
+		return image.Pt(w-1-p.X, h-1-p.Y)
+	case Rotate270:
+		return image.Pt(p.Y, h-1-p.X)
+	default:
+		return p
+	}
+}
+
+// toPanelRect converts a rectangle in display coordinates to panel
+// coordinates. The corner pixels are converted, then the rectangle is made
+// exclusive again, since rotation and mirroring may swap its corners.
+func (d *Dev) toPanelRect(r image.Rectangle) image.Rectangle {
+	if r.Empty() {
+		return image.Rectangle{}
+	}
+	a := d.toPanel(r.Min)
+	b := d.toPanel(r.Max.Sub(image.Pt(1, 1)))
+	pr := image.Rectangle{a, b}.Canon()
+	pr.Max = pr.Max.Add(image.Pt(1, 1))
+	return pr
+}
+
+// view exposes a frame buffer in panel coordinates as a draw.Image in
+// display coordinates.
+type view struct {
+	d   *Dev
+	img *image1bit.VerticalLSB
+}
+
+func (v *view) ColorModel() color.Model {
+	return image1bit.BitModel
+}
+
+func (v *view) Bounds() image.Rectangle {
+	return v.d.bounds()
+}
+
+func (v *view) At(x, y int) color.Color {
+	return v.BitAt(x, y)
+}
+
+func (v *view) BitAt(x, y int) image1bit.Bit {
+	p := v.d.toPanel(image.Pt(x, y))
+	return v.img.BitAt(p.X, p.Y)
+}
+
+func (v *view) Set(x, y int, c color.Color) {
+	if !image.Pt(x, y).In(v.Bounds()) {
+		return
+	}
+	p := v.d.toPanel(image.Pt(x, y))
+	v.img.Set(p.X, p.Y, c)
+}
+
+func (v *view) SetBit(x, y int, b image1bit.Bit) {
+	if !image.Pt(x, y).In(v.Bounds()) {
+		return
+	}
+	p := v.d.toPanel(image.Pt(x, y))
+	v.img.SetBit(p.X, p.Y, b)
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+func TestRotationBounds(t *testing.T) {
+	for _, c := range []struct {
+		r    Rotation
+		want image.Rectangle
+	}{
+		{Rotate0, image.Rect(0, 0, 122, 250)},
+		{Rotate90, image.Rect(0, 0, 250, 122)},
+		{Rotate180, image.Rect(0, 0, 122, 250)},
+		{Rotate270, image.Rect(0, 0, 250, 122)},
+	} {
+		d, _ := newTestDev(t, EPD2in13V2)
+		d.SetRotation(c.r)
+		b := d.Bounds()
+		if b != c.want {
+			t.Errorf("rotation %d: Bounds() = %v, want %v", c.r, b, c.want)
+		}
+		// Drawing the whole Bounds covers the whole panel.
+		if err := d.Draw(b, &image.Uniform{image1bit.Off}, image.Point{}); err != nil {
+			t.Fatal(err)
+		}
+		checkFrame(t, d.buffer, d.panelBounds())
+	}
+}
+
+// partialRects are drawn by the rotated partial update tests, a 2x2 square
+// and a 14x7 rectangle off the RAM byte boundaries.
+var partialRects = []image.Rectangle{image.Rect(10, 20, 12, 22), image.Rect(98, 100, 112, 107)}
+
+var entryModes = []DataEntryMode{DataEntryXDecYDec, DataEntryXIncYDec, DataEntryXDecYInc, DataEntryXIncYInc}
+
+func TestDrawPartialRotated(t *testing.T) {
+	for _, r := range []Rotation{Rotate0, Rotate90, Rotate180, Rotate270} {
+		for _, m := range entryModes {
+			d, c := newTestDev(t, EPD2in13V2)
+			d.SetRotation(r)
+			if err := d.SetDataEntryMode(m); err != nil {
+				t.Fatal(err)
+			}
+			checkPartial(t, d, c, func(rect image.Rectangle) error {
+				return d.DrawPartial(rect, image.Black, image.Point{})
+			})
+		}
+	}
+}
+
+func TestRefreshPartialRotated(t *testing.T) {
+	for _, r := range []Rotation{Rotate0, Rotate90, Rotate180, Rotate270} {
+		d, c := newTestDev(t, EPD2in13V2)
+		d.SetRotation(r)
+		if err := d.SetRefreshMode(RefreshPartial); err != nil {
+			t.Fatal(err)
+		}
+		checkPartial(t, d, c, func(rect image.Rectangle) error {
+			d.DrawBuffer(rect, image.Black, image.Point{})
+			return d.Refresh()
+		})
+	}
+}
+
+// checkPartial draws a white frame, then each of partialRects with
+// drawRect, and checks that the RAM and the displayed image match the frame
+// buffer.
+func checkPartial(t *testing.T, d *Dev, c *fakeConn, drawRect func(image.Rectangle) error) {
+	t.Helper()
+	sim := newRAMSim(d.entry)
+	if err := d.Draw(d.Bounds(), image.White, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, rect := range partialRects {
+		if err := drawRect(rect); err != nil {
+			t.Fatal(err)
+		}
+		sim.apply(t, c.commands(t))
+		checkRAM(t, d, c, sim)
+		if !bytes.Equal(d.Image().Pix, d.Snapshot().Pix) {
+			t.Fatalf("rotation %d, mirror %t %t, entry %#x: displayed image differs from the frame buffer after drawing %v", d.rotation, d.mirrorX, d.mirrorY, d.entry, rect)
+		}
+	}
+}
+
+func TestDrawPartialMirrored(t *testing.T) {
+	d, _ := newTestDev(t, EPD2in13V2)
+	d.SetMirror(true, true)
+	if got, want := d.toPanelRect(image.Rect(98, 189, 112, 196)), image.Rect(10, 54, 24, 61); got != want {
+		t.Fatalf("toPanelRect = %v, want %v", got, want)
+	}
+	for _, r := range []Rotation{Rotate0, Rotate90, Rotate180, Rotate270} {
+		for _, m := range [][2]bool{{true, false}, {false, true}, {true, true}} {
+			d, c := newTestDev(t, EPD2in13V2)
+			d.SetRotation(r)
+			d.SetMirror(m[0], m[1])
+			checkPartial(t, d, c, func(rect image.Rectangle) error {
+				return d.DrawPartial(rect, image.Black, image.Point{})
+			})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// FitMode selects how DrawScaled scales an image to the display.
+type FitMode int
+
+// Supported fit modes.
+const (
+	// Fit scales the image to fit the display, preserving its aspect ratio.
+	// The image is centered and the uncovered areas are white.
+	Fit FitMode = iota
+	// Fill scales the image to cover the display, preserving its aspect
+	// ratio. The image is centered and cropped.
+	Fill
+	// Stretch scales the image to the size of the display, ignoring its
+	// aspect ratio.
+	Stretch
+)
+
+func (f FitMode) String() string {
+	switch f {
+	case Fit:
+		return "fit"
+	case Fill:
+		return "fill"
+	case Stretch:
+		return "stretch"
+	default:
+		return fmt.Sprintf("FitMode(%d)", int(f))
+	}
+}
+
+// DrawScaled scales src to the display as selected by fit, replaces the
+// whole frame buffer with it and refreshes the display. Rotation applies, so
+// the display is 250x122 in landscape orientation.
+//
+// src is resampled with the Catmull-Rom kernel, composited over white and
+// thresholded. For photos, scale src with golang.org/x/image/draw and use
+// DrawDithered instead.
+func (d *Dev) DrawScaled(src image.Image, fit FitMode) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	b := d.bounds()
+	dr, err := fitRect(b, src.Bounds(), fit)
+	if err != nil {
+		return err
+	}
+	img := image.NewGray(b)
+	xdraw.Draw(img, b, image.White, image.Point{}, xdraw.Src)
+	if !dr.Empty() {
+		xdraw.CatmullRom.Scale(img, dr, src, src.Bounds(), xdraw.Over, nil)
+	}
+	d.drawBuffer(b, img, b.Min)
+	return d.refresh(context.Background())
+}
+
+// fitRect returns the rectangle of b that sr is scaled to, as selected by
+// fit. It extends beyond b for Fill.
+func fitRect(b, sr image.Rectangle, fit FitMode) (image.Rectangle, error) {
+	w, h, sw, sh := b.Dx(), b.Dy(), sr.Dx(), sr.Dy()
+	if sw <= 0 || sh <= 0 {
+		return image.Rectangle{}, nil
+	}
+	switch fit {
+	case Fit, Fill:
+		// Compare the aspect ratios: true if src is relatively narrower.
+		narrow := sw*h < sh*w
+		if narrow == (fit == Fit) {
+			w = sw * h / sh
+		} else {
+			h = sh * w / sw
+		}
+	case Stretch:
+	default:
+		return image.Rectangle{}, fmt.Errorf("waveshare213v2: invalid fit mode %s", fit)
+	}
+	min := b.Min.Add(image.Pt((b.Dx()-w)/2, (b.Dy()-h)/2))
+	return image.Rectangle{min, min.Add(image.Pt(w, h))}, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"image"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// SetPixel sets the pixel (x, y) of the frame buffer to c, in display
+// coordinates. Pixels out of Bounds are ignored.
+//
+// Like DrawBuffer, nothing is sent to the display; use Refresh once done.
+func (d *Dev) SetPixel(x, y int, c image1bit.Bit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setPixel(x, y, c)
+}
+
+// DrawLine draws a line from (x0, y0) to (x1, y1), both included, into the
+// frame buffer. The parts out of Bounds are clipped.
+//
+// Like DrawBuffer, nothing is sent to the display; use Refresh once done.
+func (d *Dev) DrawLine(x0, y0, x1, y1 int, c image1bit.Bit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drawLine(x0, y0, x1, y1, c)
+}
+
+// DrawRect draws the outline of r, one pixel wide and inside r, into the
+// frame buffer. Use DrawBuffer with an image.Uniform to fill a rectangle.
+//
+// Like DrawBuffer, nothing is sent to the display; use Refresh once done.
+func (d *Dev) DrawRect(r image.Rectangle, c image1bit.Bit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r = r.Canon()
+	if r.Empty() {
+		return
+	}
+	x1, y1 := r.Max.X-1, r.Max.Y-1
+	d.drawLine(r.Min.X, r.Min.Y, x1, r.Min.Y, c)
+	d.drawLine(r.Min.X, y1, x1, y1, c)
+	d.drawLine(r.Min.X, r.Min.Y, r.Min.X, y1, c)
+	d.drawLine(x1, r.Min.Y, x1, y1, c)
+}
+
+// drawLine draws a line with Bresenham's algorithm.
+func (d *Dev) drawLine(x0, y0, x1, y1 int, c image1bit.Bit) {
+	dx, sx := x1-x0, 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	dy, sy := y0-y1, 1
+	if dy > 0 {
+		dy, sy = -dy, -1
+	}
+	e := dx + dy
+	for {
+		d.setPixel(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// setPixel sets a pixel in display coordinates as drawBuffer does, removing
+// red on tri-color panels.
+func (d *Dev) setPixel(x, y int, c image1bit.Bit) {
+	if !image.Pt(x, y).In(d.bounds()) {
+		return
+	}
+	p := d.toPanel(image.Pt(x, y))
+	d.buffer.SetBit(p.X, p.Y, c)
+	if d.red != nil {
+		d.red.SetBit(p.X, p.Y, image1bit.Off)
+	}
+	d.dirty = d.dirty.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"periph.io/x/periph/conn/gpio"
+)
+
+// Status is the state of the controller as returned by Status.
+type Status struct {
+	// Busy is the state of the busy line, false if it isn't connected.
+	Busy bool
+	// HVReady is set when the high voltage of the analog blocks is ready.
+	HVReady bool
+	// VCILow is set when the supply voltage VCI was detected below 2.5V.
+	VCILow bool
+	// ChipID is the 2 bit chip ID, never zero.
+	ChipID byte
+}
+
+// Status reads the status bit register of the controller, e.g. to check that
+// it responds.
+//
+// The status can only be read with a connection that reads back; otherwise
+// ErrNoReadback is returned along with the state of the busy line. See
+// ErrNoReadback for the wiring required. Without readback, Init is the best
+// liveness check: it fails with a timeout if the controller doesn't release
+// the busy line after its reset.
+func (d *Dev) Status() (Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := Status{Busy: d.Busy()}
+	if d.sleeping {
+		return s, d.sleepingErr()
+	}
+	if !d.readback {
+		return s, ErrNoReadback
+	}
+	if err := d.sendCommand(statusBitRead); err != nil {
+		return s, err
+	}
+	if err := d.dc.Out(gpio.High); err != nil {
+		return s, err
+	}
+	r := make([]byte, 1)
+	if err := d.conn.Tx(make([]byte, 1), r); err != nil {
+		return s, err
+	}
+	// Bits 7-6 and 3 are always zero; bit 2 is the busy flag. An unconnected
+	// line reads as all ones, or all zeros when pulled down, and a valid chip
+	// ID is never zero.
+	if r[0]&0xC8 != 0 || r[0]&0x03 == 0 {
+		return s, ErrNoReadback
+	}
+	s.HVReady = r[0]&0x20 == 0
+	s.VCILow = r[0]&0x10 != 0
+	s.ChipID = r[0] & 0x03
+	return s, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/physic"
+)
+
+// ErrNoReadback is returned when reading from the controller yields no data.
+//
+// The controller returns data on its bidirectional SDA line, which the
+// standard HAT only connects to MOSI. Reading requires SDA to also be wired
+// to MISO, declared with WithReadback, or a port supporting spi.HalfDuplex.
+var ErrNoReadback = errors.New("waveshare213v2: no data read back, the SPI connection is write-only")
+
+// WithReadback declares that the controller's SDA line is also wired to
+// MISO, so that reading registers, e.g. with ReadTemperature or Status, works
+// on a full duplex connection. It is implied by a half duplex connection,
+// see spi.HalfDuplex.
+//
+// Without it, reads return ErrNoReadback without accessing the controller: an
+// unconnected MISO reads as all zeros on the Raspberry Pi, which would
+// otherwise be taken for a valid 0°C reading.
+func WithReadback() Option {
+	return func(o *options) {
+		o.readback = true
+	}
+}
+
+// ReadTemperatureRaw triggers a measurement of the internal temperature
+// sensor and returns the 12 bit temperature register, in two's complement
+// 1/16°C units.
+func (d *Dev) ReadTemperatureRaw() (uint16, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.readTemperatureRaw()
+}
+
+// readTemperatureRaw is ReadTemperatureRaw with d.mu held.
+func (d *Dev) readTemperatureRaw() (uint16, error) {
+	if d.sleeping {
+		return 0, d.sleepingErr()
+	}
+	if !d.readback {
+		return 0, ErrNoReadback
+	}
+	if err := d.loadTemperature(); err != nil {
+		return 0, err
+	}
+	if err := d.sendCommand(readTemperatureRegister); err != nil {
+		return 0, err
+	}
+	if err := d.dc.Out(gpio.High); err != nil {
+		return 0, err
+	}
+	r := make([]byte, 2)
+	if err := d.conn.Tx(make([]byte, 2), r); err != nil {
+		return 0, err
+	}
+	// A floating or unconnected line reads as all ones; the 4 lowest bits of a
+	// valid reading are always zero. All zeros can't be told apart from 0°C.
+	if r[1]&0x0F != 0 {
+		return 0, ErrNoReadback
+	}
+	return uint16(r[0])<<4 | uint16(r[1])>>4, nil
+}
+
+// LoadTemperature loads the temperature used to select waveforms, measured by
+// the internal sensor. While SetTemperatureOverride is in effect, the
+// override is written again instead. Nothing is read back, so it works with
+// write-only wiring. Refreshes selecting their waveform without loading the
+// temperature, see SetFullRefreshOption, then use it until the next load.
+func (d *Dev) LoadTemperature() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	return d.loadTemperature()
+}
+
+// loadTemperature is LoadTemperature with d.mu held.
+func (d *Dev) loadTemperature() error {
+	if d.tempOverride != nil {
+		// Loading would read the external sensor over the register.
+		return d.sendCommand(writeTemperatureRegister, d.tempOverride...)
+	}
+	// Enable clock, load temperature, disable clock.
+	if err := d.sendCommand(displayUpdateControl2, 0xA1); err != nil {
+		return err
+	}
+	if err := d.sendCommand(masterActivation); err != nil {
+		return err
+	}
+	return d.waitUntilIdle(context.Background(), "temperature measurement", noBusyDelay)
+}
+
+// ReadTemperature returns the temperature measured by the controller's
+// internal sensor.
+//
+// See ErrNoReadback for the wiring required.
+func (d *Dev) ReadTemperature() (physic.Temperature, error) {
+	raw, err := d.ReadTemperatureRaw()
+	if err != nil {
+		return 0, err
+	}
+	// Sign extend the 12 bit value.
+	v := int16(raw<<4) >> 4
+	return physic.ZeroCelsius + physic.Temperature(v)*physic.Celsius/16, nil
+}
+
+// SetTemperatureOverride makes the controller select waveforms for t instead
+// of the temperature measured by its internal sensor, e.g. when the ambient
+// temperature is known precisely. t must be from -128°C up to 128°C and is
+// rounded down to 1/16°C.
+//
+// The temperature is used by subsequent full refreshes, which then don't
+// measure the temperature, so it must be set before Update to take effect.
+// ReadTemperature is meaningless while the override is set. The setting is
+// kept across Init.
+func (d *Dev) SetTemperatureOverride(t physic.Temperature) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if t < physic.ZeroCelsius-128*physic.Celsius || t >= physic.ZeroCelsius+128*physic.Celsius {
+		return fmt.Errorf("waveshare213v2: temperature override %s is out of range", t)
+	}
+	v := int((t - physic.ZeroCelsius) * 16 / physic.Celsius)
+	if t < physic.ZeroCelsius && (t-physic.ZeroCelsius)*16%physic.Celsius != 0 {
+		v--
+	}
+	data := []byte{byte(v >> 4), byte(v << 4)}
+	// External sensor: the controller only uses the written register.
+	if err := d.sendCommand(temperatureSensorControl, 0x48); err != nil {
+		return err
+	}
+	if err := d.sendCommand(writeTemperatureRegister, data...); err != nil {
+		return err
+	}
+	d.tempOverride = data
+	return nil
+}
+
+// ClearTemperatureOverride restores the use of the internal temperature
+// sensor.
+func (d *Dev) ClearTemperatureOverride() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(temperatureSensorControl, 0x80); err != nil {
+		return err
+	}
+	d.tempOverride = nil
+	return nil
+}
+
+// temperatureInit returns the commands configuring the temperature sensor in
+// Init.
+func (d *Dev) temperatureInit() []initCommand {
+	if d.tempOverride == nil {
+		return []initCommand{{cmd: temperatureSensorControl, data: []byte{0x80}}}
+	}
+	return []initCommand{
+		{cmd: temperatureSensorControl, data: []byte{0x48}},
+		{cmd: writeTemperatureRegister, data: d.tempOverride},
+	}
+}
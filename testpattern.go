@@ -0,0 +1,59 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// bayer4 is the 4x4 ordered dithering matrix used for the test pattern
+// gradient.
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// DrawTestPattern replaces the frame buffer with a test pattern and refreshes
+// the display, e.g. to check the wiring of a new panel.
+//
+// The pattern is framed by a one pixel black border, that must be visible on
+// all four sides. The top half is an 8 pixel checkerboard, starting with a
+// black square in the top left corner, which shows the orientation and that
+// bytes are ordered correctly. The bottom half is a gradient from black on the
+// left to white on the right, dithered.
+func (d *Dev) DrawTestPattern() error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	b := d.bounds()
+	w, h := b.Dx(), b.Dy()
+	img := image1bit.NewVerticalLSB(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var c image1bit.Bit
+			switch {
+			case x == 0 || y == 0 || x == w-1 || y == h-1:
+				c = image1bit.Off
+			case y < h/2:
+				c = image1bit.Bit((x/8+y/8)%2 == 1)
+			default:
+				// Gray level of the column, in 17 steps of the matrix.
+				level := x * 17 / w
+				c = image1bit.Bit(level > bayer4[y%4][x%4])
+			}
+			img.SetBit(b.Min.X+x, b.Min.Y+y, c)
+		}
+	}
+	d.drawBuffer(b, img, b.Min)
+	return d.refresh(context.Background())
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"image"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// DrawString draws text with face into the frame buffer, pt being the left
+// end of the baseline, without refreshing the display. Rotation and
+// mirroring apply. On tri-color panels, red is removed from the bounds of
+// the text.
+//
+// Use Refresh or DrawPartial to show it.
+func (d *Dev) DrawString(text string, face font.Face, pt image.Point, c image1bit.Bit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dot := fixed.P(pt.X, pt.Y)
+	dr := font.Drawer{
+		Dst:  &view{d, d.buffer},
+		Src:  &image.Uniform{c},
+		Face: face,
+		Dot:  dot,
+	}
+	dr.DrawString(text)
+
+	// Track the glyphs' bounds like drawBuffer does.
+	b, _ := font.BoundString(face, text)
+	r := image.Rect(
+		(dot.X + b.Min.X).Floor(), (dot.Y + b.Min.Y).Floor(),
+		(dot.X + b.Max.X).Ceil(), (dot.Y + b.Max.Y).Ceil(),
+	).Intersect(d.bounds())
+	if r.Empty() {
+		return
+	}
+	if d.red != nil {
+		draw.Draw(&view{d, d.red}, r, &image.Uniform{image1bit.Off}, image.Point{}, draw.Src)
+	}
+	d.dirty = d.dirty.Union(d.toPanelRect(r))
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"time"
+
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// triColorBusyTimeout is the default BusyTimeout for tri-color panels, whose
+// refresh takes about 15 seconds.
+const triColorBusyTimeout = 30 * time.Second
+
+var errNotTriColor = errors.New("waveshare213v2: red requires a tri-color panel, see WithTriColor")
+
+// WithTriColor selects the black/white/red variant of the panel, whose second
+// RAM plane drives the red pigment.
+//
+// A full refresh of these panels is much slower, about 15 seconds, and they
+// don't support partial refreshes.
+func WithTriColor() Option {
+	return func(o *options) {
+		o.triColor = true
+	}
+}
+
+// DrawColor is like Draw, except that red pixels of src are drawn red. A
+// pixel is considered red when its red channel is above half intensity and
+// both others are below.
+//
+// It returns an error if the panel wasn't configured with WithTriColor.
+func (d *Dev) DrawColor(dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	if src == nil {
+		return errNilSource
+	}
+	if d.red == nil {
+		return errNotTriColor
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() {
+		return nil
+	}
+	if err := checkSource(src, r, sp); err != nil {
+		return err
+	}
+	d.drawBuffer(r, image.White, image.Point{})
+	d.drawBuffer(r, src, sp)
+
+	bw, red := &view{d, d.buffer}, &view{d, d.red}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if isRed(src.At(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y)) {
+				bw.SetBit(x, y, image1bit.On)
+				red.SetBit(x, y, image1bit.On)
+			}
+		}
+	}
+	return d.refresh(context.Background())
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, a := c.RGBA()
+	return a >= 0x8000 && r >= 0x8000 && g < 0x8000 && b < 0x8000
+}
+
+// DrawLayers draws black and color, aligned to the top left corner of the
+// display, as the two planes of a tri-color panel and refreshes the display.
+//
+// black is drawn as by Draw over the whole display, to the black and white
+// RAM. color is a mask written to the red RAM: a pixel is red where color
+// converts to image1bit.On, i.e. where it is light and opaque, so that an
+// *image1bit.VerticalLSB can be used as is; areas outside of color aren't red.
+// The controller shows red wherever the red plane is set, whatever the black
+// plane, and the black plane elsewhere.
+//
+// It returns an error if the panel wasn't configured with WithTriColor. On
+// black and white panels, the second RAM holds the previous frame used by
+// partial refreshes and is managed by the driver.
+func (d *Dev) DrawLayers(black, color image.Image) error {
+	if black == nil || color == nil {
+		return errNilSource
+	}
+	if d.red == nil {
+		return errNotTriColor
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	b := d.bounds()
+	d.drawBuffer(b, image.White, image.Point{})
+	d.drawBuffer(b, black, black.Bounds().Min)
+
+	red := &view{d, d.red}
+	cb := color.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := cb.Min.Add(image.Pt(x-b.Min.X, y-b.Min.Y))
+			if p.In(cb) && image1bit.BitModel.Convert(color.At(p.X, p.Y)) == image1bit.On {
+				red.SetBit(x, y, image1bit.On)
+			}
+		}
+	}
+	return d.refresh(context.Background())
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"fmt"
+)
+
+// RAMOption selects how the content of a RAM plane is used when refreshing
+// the display.
+type RAMOption byte
+
+// Supported RAM options.
+const (
+	// RAMNormal uses the RAM content as is.
+	RAMNormal RAMOption = 0x0
+	// RAMBypass ignores the RAM content, reading it as all zeros.
+	RAMBypass RAMOption = 0x4
+	// RAMInverse inverts the RAM content, e.g. to show a negative image
+	// without redrawing it.
+	RAMInverse RAMOption = 0x8
+)
+
+// SetRAMOptions configures how the black/white and red RAM planes drive the
+// display on subsequent refreshes, using display update control 1.
+//
+// For example, SetRAMOptions(RAMInverse, RAMNormal) shows a negative image,
+// and SetRAMOptions(RAMNormal, RAMBypass) runs a tri-color panel in black
+// and white. The setting is kept across Init.
+func (d *Dev) SetRAMOptions(bw, red RAMOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	for _, o := range []RAMOption{bw, red} {
+		if o != RAMNormal && o != RAMBypass && o != RAMInverse {
+			return fmt.Errorf("waveshare213v2: invalid RAM option 0x%X", byte(o))
+		}
+	}
+	v := byte(red)<<4 | byte(bw)
+	data := []byte{v}
+	if d.cfg.Controller == SSD1680 {
+		data = append(data, 0x80)
+	}
+	if err := d.sendCommand(displayUpdateControl1, data...); err != nil {
+		return err
+	}
+	d.ramOptions = v
+	return nil
+}
+
+// SetFullRefreshOption sets the display update control 2 option byte used by
+// full refreshes, 0xF7 by default. Each bit enables a step of the update
+// sequence started by master activation, run from the most significant:
+//
+//	0x80 enable the clock
+//	0x40 enable the analog blocks
+//	0x20 load the temperature, from the internal sensor or the register
+//	0x10 load the LUT from OTP, for the loaded temperature
+//	0x08 display mode 2 instead of mode 1
+//	0x04 display
+//	0x02 disable the analog blocks
+//	0x01 disable the clock
+//
+// For example, 0xFF refreshes in display mode 2. The display bit must be
+// set, as must the LUT bit: a full refresh restores the OTP waveform after
+// QuickClear, DrawGray4 or WriteLUT. o is used as is, except that loading the
+// temperature is skipped while SetTemperatureOverride is in effect. The
+// setting is kept across Init.
+//
+// 0xD7 only skips the temperature measurement: the LUT is selected for the
+// temperature last loaded, by a previous refresh or LoadTemperature, e.g.
+// once at startup. This shortens each refresh when refreshing often in a
+// stable environment, but if the temperature drifts the waveform no longer
+// matches it, giving poorer contrast or more ghosting; call LoadTemperature
+// from time to time to catch up.
+func (d *Dev) SetFullRefreshOption(o byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if o&0x04 == 0 {
+		return fmt.Errorf("waveshare213v2: full refresh option 0x%02X doesn't display", o)
+	}
+	if o&0x10 == 0 {
+		return fmt.Errorf("waveshare213v2: full refresh option 0x%02X doesn't load the LUT from OTP", o)
+	}
+	d.fullOption = o
+	return nil
+}
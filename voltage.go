@@ -0,0 +1,93 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+// SetGateVoltage sets the gate driving voltage VGH, as encoded by the
+// SSD1675B datasheet: 0x03 is 10V to 0x17, 20V, in steps of 0.5V. The
+// Waveshare reference uses 0x15, 19V.
+//
+// The setting is kept across Init and applies from the next refresh.
+func (d *Dev) SetGateVoltage(vgh byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(gateDrivingVoltageControl, vgh); err != nil {
+		return err
+	}
+	d.voltages.gate = []byte{vgh}
+	return nil
+}
+
+// SetSourceVoltage sets the source driving voltages VSH1, VSH2 and VSL, as
+// encoded by the SSD1675B datasheet. VSH1 and VSH2 range from 2.4V to 17V and
+// VSL from -9V to -17V. The Waveshare reference uses 0x41 (15V), 0xA8 and 0x32
+// (-15V).
+//
+// Higher voltages give a darker black at the cost of ghosting and panel
+// wear. The setting is kept across Init and applies from the next refresh.
+func (d *Dev) SetSourceVoltage(vsh1, vsh2, vsl byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(sourceDrivingVoltageControl, vsh1, vsh2, vsl); err != nil {
+		return err
+	}
+	d.voltages.source = []byte{vsh1, vsh2, vsl}
+	return nil
+}
+
+// SetVCOM sets the VCOM voltage, as encoded by the SSD1675B datasheet: 0x08
+// is -0.2V to 0x78, -3V, in steps of 25mV.
+//
+// Once set, it replaces the VCOM otherwise set by SetRefreshMode and
+// QuickClear for the fast and partial waveforms. The setting is kept across
+// Init.
+func (d *Dev) SetVCOM(vcom byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(writeVCOMRegister, vcom); err != nil {
+		return err
+	}
+	d.voltages.vcom = []byte{vcom}
+	return nil
+}
+
+// vcom returns the VCOM set by SetVCOM, or def if unset.
+func (d *Dev) vcom(def byte) byte {
+	if d.voltages.vcom != nil {
+		return d.voltages.vcom[0]
+	}
+	return def
+}
+
+// voltages holds the driving voltages set by SetGateVoltage, SetSourceVoltage
+// and SetVCOM, nil when not set.
+type voltages struct {
+	gate   []byte
+	source []byte
+	vcom   []byte
+}
+
+// initCommands returns the commands restoring the voltages in Init.
+func (v *voltages) initCommands() []initCommand {
+	var seq []initCommand
+	if v.gate != nil {
+		seq = append(seq, initCommand{cmd: gateDrivingVoltageControl, data: v.gate})
+	}
+	if v.source != nil {
+		seq = append(seq, initCommand{cmd: sourceDrivingVoltageControl, data: v.source})
+	}
+	if v.vcom != nil {
+		seq = append(seq, initCommand{cmd: writeVCOMRegister, data: v.vcom})
+	}
+	return seq
+}
@@ -5,10 +5,14 @@
 package waveshare213v2
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"sync"
 	"time"
 
 	"periph.io/x/periph/conn"
@@ -23,12 +27,24 @@ import (
 // EPD commands
 const (
 	driverOutputControl            byte = 0x01
+	gateDrivingVoltageControl      byte = 0x03
+	sourceDrivingVoltageControl    byte = 0x04
+	gateScanStartPosition          byte = 0x0F
+	deepSleepMode                  byte = 0x10
 	dataEntryModeSetting           byte = 0x11
 	swReset                        byte = 0x12
 	temperatureSensorControl       byte = 0x18
+	writeTemperatureRegister       byte = 0x1A
+	readTemperatureRegister        byte = 0x1B
 	masterActivation               byte = 0x20
+	displayUpdateControl1          byte = 0x21
 	displayUpdateControl2          byte = 0x22
 	writeRAMBW                     byte = 0x24
+	writeRAMRed                    byte = 0x26
+	writeVCOMRegister              byte = 0x2C
+	statusBitRead                  byte = 0x2F
+	writeLUTRegister               byte = 0x32
+	writeDisplayOptionRegister     byte = 0x37
 	borderWaveformControl          byte = 0x3C
 	setRAMXAddressStartEndPosition byte = 0x44
 	setRAMYAddressStartEndPosition byte = 0x45
@@ -36,179 +52,1724 @@ const (
 	setRAMYAddressCounter          byte = 0x4F
 )
 
+// ErrSleeping is returned when the display is accessed while in deep sleep.
+var ErrSleeping = errors.New("waveshare213v2: display is in deep sleep, call Init first")
+
+// ErrClosed is returned when the display is accessed after Close.
+var ErrClosed = errors.New("waveshare213v2: display is closed, call Init first")
+
+// ErrBusy is returned when drawing while the controller is still busy with a
+// refresh, e.g. one left running by a done context. The draw can be retried
+// once the controller is idle, see WaitUntilIdle.
+var ErrBusy = errors.New("waveshare213v2: display is busy")
+
+var errNilSource = errors.New("waveshare213v2: nil source image")
+
+// DefaultBusyTimeout is the default value of Dev.BusyTimeout.
+const DefaultBusyTimeout = 5 * time.Second
+
+// DefaultMinRefreshInterval is the default value of Dev.MinRefreshInterval.
+const DefaultMinRefreshInterval = 180 * time.Millisecond
+
+// noBusyDelay is the time waited for short operations when the busy line isn't
+// connected.
+const noBusyDelay = 100 * time.Millisecond
+
+// The busy line is polled every minBusyPoll at first, doubling up to
+// maxBusyPoll.
 const (
-	displayWidth  = 122
-	displayHeight = 250
+	minBusyPoll = time.Millisecond
+	maxBusyPoll = 50 * time.Millisecond
 )
 
+// defaultRetryBackoff is the delay before the first retry when
+// Dev.RetryBackoff is zero.
+const defaultRetryBackoff = 10 * time.Millisecond
+
+// Logger is the interface of Dev.Logger, implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// ResetTiming is the timing of the hardware reset sequence: the reset line is
+// held high for High, pulled low for Low, then released for Settle before the
+// controller is accessed.
+type ResetTiming struct {
+	High   time.Duration
+	Low    time.Duration
+	Settle time.Duration
+}
+
+// DefaultResetTiming is the default value of Dev.ResetTiming.
+var DefaultResetTiming = ResetTiming{
+	High:   20 * time.Millisecond,
+	Low:    20 * time.Millisecond,
+	Settle: 200 * time.Millisecond,
+}
+
 // Dev is an open handle to the display controller.
+//
+// Dev is safe for concurrent use: operations are serialized, a draw waiting
+// for the one in progress to complete. The exported fields must not be
+// changed while the Dev is in use by other goroutines.
 type Dev struct {
+	// BusyTimeout is the maximum time to wait for the controller to release
+	// the busy line. Zero waits forever.
+	BusyTimeout time.Duration
+	// FullRefreshEvery makes every FullRefreshEvery-th update in
+	// RefreshPartial mode a full refresh, to clear the ghosting that builds up
+	// with partial refreshes. Zero disables it.
+	FullRefreshEvery int
+	// ResetTiming is used by Reset. Lengthen it if the panel intermittently
+	// fails to initialize.
+	ResetTiming ResetTiming
+	// Gray4 maps gray levels to RAM bits in DrawGray4.
+	Gray4 Gray4Mapping
+	// MinRefreshInterval is the minimum time between the end of a refresh and
+	// the start of the next one; refreshes requested earlier are delayed, to
+	// protect the panel from being refreshed continuously. Zero disables it.
+	MinRefreshInterval time.Duration
+	// OnRefresh, if set, is called with the duration of each refresh once the
+	// controller is done, e.g. to collect metrics. It is called with the Dev
+	// locked and must not call its methods.
+	OnRefresh func(time.Duration)
+	// OnWrite, if set, is called with the number of bytes of each data
+	// transfer to the controller. Like OnRefresh, it must not call the Dev.
+	OnWrite func(int)
+	// MaxTxSize limits the size of a single SPI transfer; larger writes are
+	// split. The limit reported by the SPI connection, if any, is always
+	// honored. Zero means no additional limit.
+	MaxTxSize int
+	// Logger, if set, logs each command sent and the time spent waiting for
+	// the controller, e.g. to share a trace when reporting a problem. Like
+	// OnRefresh, it must not call the Dev.
+	Logger Logger
+	// AutoRefresh makes Draw, DrawContext and Overlay refresh the display.
+	// When false, they only write the frame buffer to RAM and the display is
+	// refreshed by a later Refresh or Update, e.g. to compose several draws
+	// into a single refresh; Image returns the frame once refreshed. It is
+	// true by default.
+	AutoRefresh bool
+	// MaxRetries is the number of times a command or RAM write failing on a
+	// transient SPI or GPIO error is retried, e.g. on long cables. Zero, the
+	// default, reports the first error so that wiring faults aren't masked.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled on each
+	// subsequent one. Zero means 10ms.
+	RetryBackoff time.Duration
+
+	// mu serializes the operations on the controller and the state below.
+	mu   sync.Mutex
 	conn spi.Conn
 	dc   gpio.PinOut
 	rst  gpio.PinOut
 	busy gpio.PinIO
+
+	cfg Config
+
+	// buffer holds the frame in panel coordinates.
+	buffer *image1bit.VerticalLSB
+	// shown holds the frame currently displayed, in panel coordinates.
+	shown *image1bit.VerticalLSB
+	// red holds the red plane of tri-color panels, nil otherwise.
+	red      *image1bit.VerticalLSB
+	rotation Rotation
+	mirrorX  bool
+	mirrorY  bool
+	entry    DataEntryMode
+	mode     RefreshMode
+	partials int
+	border   BorderWaveform
+	// ramOptions is the display update control 1 value set by SetRAMOptions.
+	ramOptions byte
+	// voltages are set by SetGateVoltage, SetSourceVoltage and SetVCOM.
+	voltages voltages
+	// gates is the gate configuration set by SetGateConfig.
+	gates gateConfig
+	// tempOverride is the temperature register set by SetTemperatureOverride,
+	// nil if unset.
+	tempOverride []byte
+	// fullOption is the option byte set by SetFullRefreshOption, zero for
+	// the default.
+	fullOption byte
+	// initOverrides are applied to the initialization sequence by Init.
+	initOverrides []initCommand
+	// readback is set when reads from the controller are expected to work,
+	// see WithReadback.
+	readback bool
+	// inverted is set by SetInverted.
+	inverted bool
+	sleeping bool
+	// closed is set by Close, along with sleeping.
+	closed bool
+	// dirty is the region of the frame buffer drawn since the last refresh,
+	// in panel coordinates.
+	dirty image.Rectangle
+	// written is a copy of the frame buffer written to RAM by a draw with
+	// AutoRefresh off, shown by the next update; nil if there is none.
+	written []byte
+	// lastRefresh is the time of the last refresh, see LastRefresh.
+	lastRefresh time.Time
+	// lastDuration is the duration of the last refresh, see
+	// LastRefreshDuration.
+	lastDuration time.Duration
+	// poweredOff is set when the clock and analog blocks were disabled by
+	// PowerOff.
+	poweredOff bool
+	// retrying is set while retry runs an operation.
+	retrying bool
+}
+
+// Option configures a Dev at construction time.
+type Option func(*options)
+
+type options struct {
+	spiFrequency physic.Frequency
+	spiMode      spi.Mode
+	bitsPerWord  int
+	triColor     bool
+	skipInit     bool
+	readback     bool
+	initCommands []initCommand
+}
+
+// WithSPIFrequency sets the SPI clock frequency. The default is 10MHz.
+//
+// Lower it when long cables or cheap clones corrupt the image.
+func WithSPIFrequency(f physic.Frequency) Option {
+	return func(o *options) {
+		o.spiFrequency = f
+	}
+}
+
+// WithSPIMode sets the SPI mode. The default, spi.Mode0, is the one expected
+// by the controller; change it only for bridges or level shifters that alter
+// the clock.
+func WithSPIMode(m spi.Mode) Option {
+	return func(o *options) {
+		o.spiMode = m
+	}
+}
+
+// WithBitsPerWord sets the number of bits per SPI word. The default is 8.
+func WithBitsPerWord(n int) Option {
+	return func(o *options) {
+		o.bitsPerWord = n
+	}
+}
+
+// WithoutInit skips the call to Init in the constructor, leaving the
+// controller and the displayed image untouched.
+//
+// This is useful to reopen a display that is still initialized, e.g. after a
+// crash, without the reset. Otherwise, Init must be called before drawing.
+func WithoutInit() Option {
+	return func(o *options) {
+		o.skipInit = true
+	}
+}
+
+// WithInitCommand makes Init send cmd with data instead of the default
+// parameters of cmd, or after the default sequence if Init doesn't send cmd,
+// e.g. to port settings of other drivers for a panel variant.
+//
+// The default sequence sets the driver output control (0x01), the data entry
+// mode (0x11), the border waveform (0x3C), the temperature sensor (0x18) and,
+// if set, the display update control 1 (0x21) and the driving voltages (0x03,
+// 0x04 and 0x2C), in that order, after the software reset. The RAM window is
+// set last. Commands whose state is tracked by Dev, e.g. the data entry mode
+// or the border waveform, should be changed with their setters instead.
+func WithInitCommand(cmd byte, data ...byte) Option {
+	return func(o *options) {
+		o.initCommands = append(o.initCommands, initCommand{cmd: cmd, data: data})
+	}
 }
 
+// WithoutInitCommand removes cmd from the sequence sent by Init, e.g. to keep
+// the temperature sensor setting of the OTP. See WithInitCommand.
+func WithoutInitCommand(cmd byte) Option {
+	return func(o *options) {
+		o.initCommands = append(o.initCommands, initCommand{cmd: cmd, skip: true})
+	}
+}
+
+// Pins are the control pins the panel is connected to.
+type Pins struct {
+	DC   gpio.PinOut
+	RST  gpio.PinOut
+	Busy gpio.PinIO
+}
+
+// HatPins are the pins of the Waveshare e-Paper HAT for Raspberry Pi, also
+// used by the e-Paper Driver HAT: DC on GPIO25 (P1_22), RST on GPIO17 (P1_11)
+// and BUSY on GPIO24 (P1_18). It is used by NewSPIHat and may be changed
+// before calling it for boards wired differently.
+var HatPins = Pins{DC: rpi.P1_22, RST: rpi.P1_11, Busy: rpi.P1_18}
+
 // NewSPIHat returns a Dev object that communicates over SPI
 // and have the default config for the e-paper hat for Raspberry Pi.
-func NewSPIHat(p spi.Port) (*Dev, error) {
-	return NewSPI(p, rpi.P1_22, rpi.P1_11, rpi.P1_18)
+func NewSPIHat(p spi.Port, opts ...Option) (*Dev, error) {
+	return NewSPIPins(p, HatPins, opts...)
+}
+
+// NewSPIPins is like NewSPI with the pins given as Pins, e.g. a modified copy
+// of HatPins.
+func NewSPIPins(p spi.Port, pins Pins, opts ...Option) (*Dev, error) {
+	return NewSPI(p, pins.DC, pins.RST, pins.Busy, opts...)
 }
 
 // NewSPI returns a Dev object that communicates over SPI to a e-paper display controller.
-func NewSPI(p spi.Port, dc, rst gpio.PinOut, busy gpio.PinIO) (*Dev, error) {
+//
+// busy may be nil if the busy line isn't connected. Fixed, conservative delays
+// are used instead of waiting for the controller, which is slower and less
+// reliable. dc and rst are required, and all pins must be distinct.
+//
+// If Init fails, the controller is held in reset and the Dev is returned along
+// with the error, so that Init can be retried without reopening the port.
+//
+// Several panels can share the SPI bus, each with its own chip select, and so
+// its own port, and its own control pins:
+//
+//	p0, err := spireg.Open("SPI0.0")
+//	...
+//	p1, err := spireg.Open("SPI0.1")
+//	...
+//	top, err := waveshare213v2.NewSPI(p0, rpi.P1_22, rpi.P1_11, rpi.P1_18)
+//	...
+//	bottom, err := waveshare213v2.NewSPI(p1, rpi.P1_13, rpi.P1_15, rpi.P1_16)
+//
+// The Devs are independent and may be drawn to concurrently: transfers on
+// the bus are serialized by the SPI driver, and waiting for a refresh doesn't
+// hold the bus. NewConn takes an spi.Conn instead, e.g. when chip select is
+// managed differently.
+func NewSPI(p spi.Port, dc, rst gpio.PinOut, busy gpio.PinIO, opts ...Option) (*Dev, error) {
+	return NewSPIConfig(p, EPD2in13V2, dc, rst, busy, opts...)
+}
+
+// NewSPIConfig is like NewSPI for other panels of the SSD1675 family, e.g.
+// 2.9inch ones, described by cfg.
+func NewSPIConfig(p spi.Port, cfg Config, dc, rst gpio.PinOut, busy gpio.PinIO, opts ...Option) (*Dev, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := validatePins(dc, rst, busy); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
 	if err := dc.Out(gpio.Low); err != nil {
 		return nil, err
 	}
-	conn, err := p.Connect(10*physic.MegaHertz, spi.Mode0, 8)
+	c, err := p.Connect(o.spiFrequency, o.spiMode, o.bitsPerWord)
 	if err != nil {
 		return nil, err
 	}
+	return newDev(c, cfg, dc, rst, busy, &o)
+}
 
-	d := &Dev{conn: conn, dc: dc, rst: rst, busy: busy}
-	if err := d.Init(); err != nil {
+// NewConn is like NewSPIConfig with an already open SPI connection, which
+// should use mode 0 and 8 bits per word. WithSPIFrequency, WithSPIMode and
+// WithBitsPerWord are ignored.
+//
+// It allows using a connection set up differently, or fakes from the conntest
+// and gpiotest packages of periph in tests.
+func NewConn(c spi.Conn, cfg Config, dc, rst gpio.PinOut, busy gpio.PinIO, opts ...Option) (*Dev, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := validatePins(dc, rst, busy); err != nil {
 		return nil, err
 	}
+	o := newOptions(opts)
+	if err := dc.Out(gpio.Low); err != nil {
+		return nil, err
+	}
+	return newDev(c, cfg, dc, rst, busy, &o)
+}
+
+func newOptions(opts []Option) options {
+	o := options{spiFrequency: 10 * physic.MegaHertz, spiMode: spi.Mode0, bitsPerWord: 8}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// newDev returns a Dev using c, initialized unless o.skipInit is set.
+func newDev(c spi.Conn, cfg Config, dc, rst gpio.PinOut, busy gpio.PinIO, o *options) (*Dev, error) {
+	d := &Dev{
+		BusyTimeout:        DefaultBusyTimeout,
+		MinRefreshInterval: DefaultMinRefreshInterval,
+		ResetTiming:        DefaultResetTiming,
+		Gray4:              DefaultGray4Mapping,
+		AutoRefresh:        true,
+		conn:               c,
+		dc:                 dc,
+		rst:                rst,
+		busy:               busy,
+		cfg:                cfg,
+		entry:              DataEntryXIncYDec,
+		border:             BorderWhite,
+		buffer:             image1bit.NewVerticalLSB(image.Rect(0, 0, cfg.Width, cfg.Height)),
+		shown:              image1bit.NewVerticalLSB(image.Rect(0, 0, cfg.Width, cfg.Height)),
+	}
+	draw.Draw(d.buffer, d.buffer.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(d.shown, d.shown.Bounds(), image.White, image.Point{}, draw.Src)
+	d.initOverrides = o.initCommands
+	d.readback = o.readback || c.Duplex() == conn.Half
+	if o.triColor {
+		d.BusyTimeout = triColorBusyTimeout
+		d.red = image1bit.NewVerticalLSB(d.buffer.Bounds())
+	}
+	if !o.skipInit {
+		if err := d.Init(); err != nil {
+			return d, err
+		}
+	}
 	return d, nil
 }
 
+// validatePins checks that the control pins are set and distinct. busy may be
+// nil.
+func validatePins(dc, rst gpio.PinOut, busy gpio.PinIO) error {
+	if dc == nil || dc == gpio.INVALID {
+		return errors.New("waveshare213v2: dc pin is required")
+	}
+	if rst == nil || rst == gpio.INVALID {
+		return errors.New("waveshare213v2: rst pin is required")
+	}
+	if dc.Name() == rst.Name() {
+		return errors.New("waveshare213v2: dc and rst must be different pins")
+	}
+	if busy == nil {
+		return nil
+	}
+	if busy == gpio.INVALID {
+		return errors.New("waveshare213v2: busy pin is invalid; pass nil if it isn't connected")
+	}
+	if busy.Name() == dc.Name() {
+		return errors.New("waveshare213v2: dc and busy must be different pins")
+	}
+	if busy.Name() == rst.Name() {
+		return errors.New("waveshare213v2: rst and busy must be different pins")
+	}
+	return nil
+}
+
 // String implements conn.Resource.
 func (d *Dev) String() string {
-	return fmt.Sprintf("waveshare213v2.Dev{%s, %s, %s}", d.conn, d.dc, d.Bounds().Max)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return fmt.Sprintf("waveshare213v2.Dev{%s, %s, %s}", d.conn, d.dc, d.bounds().Max)
 }
 
 // ColorModel implements display.Drawer.
 // It is a one bit color model, as implemented by image1bit.Bit.
+//
+// image1bit.On is white and image1bit.Off black, as image1bit.BitModel maps
+// light colors to On, so images are shown as they look on screen. The
+// controller RAM uses the same convention, a set bit being white, so a pixel
+// drawn On is written as a set bit unless SetInverted is on; see EncodeImage.
 func (d *Dev) ColorModel() color.Model {
 	return image1bit.BitModel
 }
 
 // Bounds implements display.Drawer.
+//
+// Width and height are swapped when the display is rotated by 90 or 270
+// degrees.
 func (d *Dev) Bounds() image.Rectangle {
-	return image.Rect(0, 0, displayWidth, displayHeight)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bounds()
+}
+
+// bounds is Bounds with d.mu held.
+func (d *Dev) bounds() image.Rectangle {
+	if d.rotation == Rotate90 || d.rotation == Rotate270 {
+		return image.Rect(0, 0, d.cfg.Height, d.cfg.Width)
+	}
+	return image.Rect(0, 0, d.cfg.Width, d.cfg.Height)
+}
+
+// panelBounds returns the bounds of the panel, regardless of rotation.
+func (d *Dev) panelBounds() image.Rectangle {
+	return image.Rect(0, 0, d.cfg.Width, d.cfg.Height)
 }
 
 // Draw implements display.Drawer.
+//
+// dstRect is clipped to Bounds and cleared to white in the frame buffer, src
+// is drawn over it, so that transparent areas of src are white, and the
+// display is refreshed. The rest of the frame buffer is kept. Nothing is done
+// if the clipped rectangle is empty, and an error is returned if src is nil.
+//
+// As with draw.Draw, sp is the point of src drawn at dstRect.Min. Parts of
+// dstRect beyond the bounds of src are white; an error is returned if the
+// source region doesn't overlap src at all, which is usually a wrong sp.
+//
+// If AutoRefresh is off, the frame is only written to RAM; call Refresh or
+// Update to show it.
 func (d *Dev) Draw(dstRect image.Rectangle, src image.Image, sp image.Point) error {
-	next := image1bit.NewVerticalLSB(image.Rect(0, 0, 128, 250))
-	draw.Draw(next, next.Bounds(), image.White, image.Point{}, draw.Src)
-	draw.Draw(next, dstRect, src, sp, draw.Src)
+	return d.DrawContext(context.Background(), dstRect, src, sp)
+}
 
-	if err := d.sendCommand(writeRAMBW); err != nil {
+// DrawContext is like Draw but stops waiting for the refresh to complete when
+// ctx is done. The frame data is always written completely.
+func (d *Dev) DrawContext(ctx context.Context, dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
 		return err
 	}
-	for y := 0; y < next.Rect.Dy(); y++ {
-		var byteToSend byte
-		for x := 0; x < next.Rect.Dx(); x++ {
-			bit := next.BitAt(next.Rect.Dx()-7-x, y)
-			if bit {
-				byteToSend |= 0x80 >> (uint32(x) % 8)
-			}
-			if x%8 == 7 {
-				if err := d.sendData(byteToSend); err != nil {
-					return err
-				}
-				byteToSend = 0x00
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() {
+		return nil
+	}
+	if err := checkSource(src, r, sp); err != nil {
+		return err
+	}
+	d.drawBuffer(r, image.White, image.Point{})
+	d.drawBuffer(r, src, sp)
+	return d.drawn(ctx)
+}
+
+// Overlay is like Draw but doesn't clear dstRect to white first: src is drawn
+// on top of the current frame buffer, which is kept where src doesn't cover
+// dstRect.
+func (d *Dev) Overlay(dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() {
+		return nil
+	}
+	if err := checkSource(src, r, sp); err != nil {
+		return err
+	}
+	d.drawBuffer(r, src, sp)
+	return d.drawn(context.Background())
+}
+
+// DrawOp is Draw with the compositing operator: draw.Src replaces dstRect,
+// transparent areas of src being white, as Draw does, and draw.Over draws src
+// on top of the current content of dstRect, as Overlay does.
+func (d *Dev) DrawOp(dstRect image.Rectangle, src image.Image, sp image.Point, op draw.Op) error {
+	switch op {
+	case draw.Src:
+		return d.Draw(dstRect, src, sp)
+	case draw.Over:
+		return d.Overlay(dstRect, src, sp)
+	default:
+		return fmt.Errorf("waveshare213v2: unsupported draw operator %d", int(op))
+	}
+}
+
+// Snapshot returns a copy of the frame buffer, in display coordinates. It
+// includes what was drawn with DrawBuffer but not refreshed yet.
+func (d *Dev) Snapshot() *image1bit.VerticalLSB {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	img := image1bit.NewVerticalLSB(d.bounds())
+	draw.Draw(img, img.Bounds(), &view{d, d.buffer}, image.Point{}, draw.Src)
+	return img
+}
+
+// Image returns a copy of the image currently displayed, in display
+// coordinates, as of the last refresh.
+func (d *Dev) Image() *image1bit.VerticalLSB {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	img := image1bit.NewVerticalLSB(d.bounds())
+	draw.Draw(img, img.Bounds(), &view{d, d.shown}, image.Point{}, draw.Src)
+	return img
+}
+
+// DrawBuffer draws src into dstRect of the frame buffer, on top of its
+// current content, without sending anything to the display. Transparent areas
+// of src keep the current content. On tri-color panels, red is removed from
+// dstRect. Nothing is drawn if src is nil.
+//
+// Use Refresh to show the frame buffer once composed.
+func (d *Dev) DrawBuffer(dstRect image.Rectangle, src image.Image, sp image.Point) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drawBuffer(dstRect, src, sp)
+}
+
+// drawBuffer is DrawBuffer with d.mu held.
+func (d *Dev) drawBuffer(dstRect image.Rectangle, src image.Image, sp image.Point) {
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() || src == nil {
+		return
+	}
+	if g, ok := src.(*image.Gray); ok {
+		d.drawGray(r, g, sp)
+	} else {
+		draw.Draw(&view{d, d.buffer}, r, src, sp, draw.Over)
+	}
+	if d.red != nil {
+		draw.Draw(&view{d, d.red}, r, &image.Uniform{image1bit.Off}, image.Point{}, draw.Src)
+	}
+	d.dirty = d.dirty.Union(d.toPanelRect(r))
+}
+
+// drawGray is the fast path of drawBuffer for *image.Gray sources, which are
+// common and opaque. Pixels are thresholded as by image1bit.BitModel and
+// written directly to the frame buffer.
+func (d *Dev) drawGray(r image.Rectangle, src *image.Gray, sp image.Point) {
+	sr := image.Rectangle{sp, sp.Add(r.Size())}.Intersect(src.Bounds())
+	r = sr.Sub(sp).Add(r.Min)
+	pix, stride := d.buffer.Pix, d.buffer.Stride
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		line := src.Pix[src.PixOffset(sr.Min.X, sr.Min.Y+y-r.Min.Y):]
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := d.toPanel(image.Pt(x, y))
+			i, mask := p.Y/8*stride+p.X, byte(1)<<uint(p.Y&7)
+			if line[x-r.Min.X] >= 0x80 {
+				pix[i] |= mask
+			} else {
+				pix[i] &^= mask
 			}
 		}
 	}
-	return d.Update()
 }
 
-// Halt implements conn.Resource. It clears the screen content.
+// begin locks d.mu for a draw, failing with ErrBusy if the controller is
+// busy. end must be called when done.
+func (d *Dev) begin() error {
+	d.mu.Lock()
+	if d.Busy() {
+		d.mu.Unlock()
+		return ErrBusy
+	}
+	return nil
+}
+
+// end unlocks d.mu after a draw started with begin.
+func (d *Dev) end() {
+	d.mu.Unlock()
+}
+
+// clip clips dstRect to the display bounds and moves sp accordingly, as
+// expected from a display.Drawer.
+func (d *Dev) clip(dstRect image.Rectangle, sp image.Point) (image.Rectangle, image.Point) {
+	r := dstRect.Intersect(d.bounds())
+	return r, sp.Add(r.Min.Sub(dstRect.Min))
+}
+
+// checkSource returns an error if the region of src drawn into r from sp
+// doesn't overlap src at all, usually because of a wrong sp. A region partly
+// out of src is allowed, the missing part being transparent.
+func checkSource(src image.Image, r image.Rectangle, sp image.Point) error {
+	sr := image.Rectangle{sp, sp.Add(r.Size())}
+	if !sr.Overlaps(src.Bounds()) {
+		return fmt.Errorf("waveshare213v2: source rectangle %v is out of the source bounds %v", sr, src.Bounds())
+	}
+	return nil
+}
+
+// Refresh writes the frame buffer to the display and refreshes it.
+//
+// In RefreshPartial mode, only the region enclosing everything drawn since
+// the last refresh is written, so that several elements drawn with DrawBuffer
+// are shown with a single partial refresh. If that region covers more than
+// half of the panel, a full refresh is done instead, as with
+// FullRefreshEvery.
+func (d *Dev) Refresh() error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if d.mode == RefreshPartial && d.red == nil && !d.dirty.Empty() {
+		return d.refreshDirty(context.Background())
+	}
+	return d.refresh(context.Background())
+}
+
+func (d *Dev) refresh(ctx context.Context) error {
+	if err := d.writeFrame(); err != nil {
+		return err
+	}
+	if err := d.update(ctx); err != nil {
+		return err
+	}
+	copy(d.shown.Pix, d.buffer.Pix)
+	d.dirty = image.Rectangle{}
+	return nil
+}
+
+// writeFrame writes the whole frame buffer, and the red plane of tri-color
+// panels, to the controller RAM.
+func (d *Dev) writeFrame() error {
+	if _, err := d.writeRAM(writeRAMBW, d.buffer, d.buffer.Bounds(), d.inverted); err != nil {
+		return err
+	}
+	if d.red != nil {
+		if _, err := d.writeRAM(writeRAMRed, d.red, d.red.Bounds(), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawn shows the frame buffer after a draw: it is refreshed, unless
+// AutoRefresh is off, in which case it is only written to RAM.
+func (d *Dev) drawn(ctx context.Context) error {
+	if !d.AutoRefresh {
+		if err := d.writeFrame(); err != nil {
+			return err
+		}
+		d.written = append(d.written[:0], d.buffer.Pix...)
+		return nil
+	}
+	return d.refresh(ctx)
+}
+
+// RefreshCurrent writes the frame buffer to the display again and refreshes it
+// with a full refresh, whatever the refresh mode, e.g. to restore the image
+// after Init or to clear the ghosting of a static image. The waveform of the
+// current mode is restored afterwards; in RefreshFast mode, a LUT loaded with
+// WriteLUT is replaced by the fast waveform.
+func (d *Dev) RefreshCurrent() error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	return d.refreshCurrent(context.Background())
+}
+
+// refreshCurrent is RefreshCurrent with d.mu held.
+func (d *Dev) refreshCurrent(ctx context.Context) error {
+	if err := d.writeFrame(); err != nil {
+		return err
+	}
+	if err := d.fullUpdate(ctx); err != nil {
+		return err
+	}
+	copy(d.shown.Pix, d.buffer.Pix)
+	d.dirty = image.Rectangle{}
+	return nil
+}
+
+// Recover brings the display back to a working state after an error, e.g. a
+// busy timeout or a failed transfer: it resets and initializes the
+// controller, restores the refresh mode and shows the frame buffer with a
+// full refresh, as RefreshCurrent. It may be used in deep sleep or after
+// Close too, and doesn't wait for the busy line to be released first.
+//
+// The settings kept across Init, including rotation, are kept. As with
+// RefreshCurrent, a LUT loaded with WriteLUT is replaced by the waveform of
+// the mode.
+func (d *Dev) Recover() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mode := d.mode
+	if err := d.initialize(); err != nil {
+		return fmt.Errorf("waveshare213v2: recovery failed: %w", err)
+	}
+	// The full refresh loads the waveform of the mode once done.
+	d.mode = mode
+	if err := d.refreshCurrent(context.Background()); err != nil {
+		return fmt.Errorf("waveshare213v2: recovery failed: %w", err)
+	}
+	return nil
+}
+
+// refreshDirty writes the region of the frame buffer drawn since the last
+// refresh and refreshes the display in RefreshPartial mode. If the region is
+// large, the whole frame is written and refreshed with a full refresh
+// instead, which also clears ghosting.
+func (d *Dev) refreshDirty(ctx context.Context) error {
+	pr := d.ramRect(d.dirty)
+	if d.largeRect(pr) {
+		if _, err := d.writeRAM(writeRAMBW, d.buffer, d.buffer.Bounds(), d.inverted); err != nil {
+			return err
+		}
+		if err := d.fullUpdate(ctx); err != nil {
+			return err
+		}
+		copy(d.shown.Pix, d.buffer.Pix)
+		d.dirty = image.Rectangle{}
+		return nil
+	}
+	if _, err := d.writeRAM(writeRAMBW, d.buffer, pr, d.inverted); err != nil {
+		return err
+	}
+	if err := d.update(ctx); err != nil {
+		return err
+	}
+	draw.Draw(d.shown, pr, d.buffer, pr.Min, draw.Src)
+	d.dirty = image.Rectangle{}
+	return nil
+}
+
+// largeRect reports whether r, in panel coordinates, covers more than half of
+// the panel, in which case writing the whole frame is not much slower.
+func (d *Dev) largeRect(r image.Rectangle) bool {
+	pb := d.panelBounds()
+	return 2*r.Dx()*r.Dy() > pb.Dx()*pb.Dy()
+}
+
+// DrawPartial draws src into dstRect of the frame buffer and refreshes only
+// that region.
+//
+// The refresh uses the current refresh mode, so the partial waveform must
+// have been loaded with SetRefreshMode or LoadPartialMode for a partial
+// refresh. The controller addresses RAM in whole bytes along the panel's
+// short side, so the window is widened to the enclosing 8 pixels there. The
+// pixels added by the widening are written from the frame buffer, so they
+// keep what was drawn there: a region starting at pixel 5 only changes pixels
+// from 5 on, although the window starts at 0.
+//
+// Only the black and white RAM is written. On tri-color panels, the red
+// plane, in the second RAM, is left intact: red pixels of dstRect stay red
+// until the next refresh writing the whole frame, e.g. Refresh.
+func (d *Dev) DrawPartial(dstRect image.Rectangle, src image.Image, sp image.Point) error {
+	if src == nil {
+		return errNilSource
+	}
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	r, sp := d.clip(dstRect, sp)
+	if r.Empty() {
+		return nil
+	}
+	if err := checkSource(src, r, sp); err != nil {
+		return err
+	}
+	d.drawBuffer(r, src, sp)
+
+	pr := d.ramRect(d.toPanelRect(r))
+	if _, err := d.writeRAM(writeRAMBW, d.buffer, pr, d.inverted); err != nil {
+		return err
+	}
+	if err := d.update(context.Background()); err != nil {
+		return err
+	}
+	draw.Draw(d.shown, pr, d.buffer, pr.Min, draw.Src)
+	return nil
+}
+
+// Clear fills the frame buffer and the display with c, image1bit.On being
+// white, and refreshes the display. Like everything drawn, c is shown inverted
+// when SetInverted is on.
+func (d *Dev) Clear(c image1bit.Bit) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	return d.clear(c)
+}
+
+// QuickClear is like Clear but uses the fast waveform in RefreshFull mode,
+// clearing in well under a second. Ghosting builds up as with RefreshFast, so
+// use Clear from time to time. There is no difference in other modes, which
+// are fast already, and on tri-color or SSD1680 panels.
+func (d *Dev) QuickClear(c image1bit.Bit) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.end()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if d.mode != RefreshFull || d.red != nil || d.cfg.Controller != SSD1675B {
+		return d.clear(c)
+	}
+	if err := d.sendCommand(writeVCOMRegister, d.vcom(0x55)); err != nil {
+		return err
+	}
+	if err := d.sendCommand(writeLUTRegister, lutFastUpdate...); err != nil {
+		return err
+	}
+	// The next full update reloads the LUT from OTP.
+	d.mode = RefreshFast
+	err := d.clear(c)
+	d.mode = RefreshFull
+	return err
+}
+
+// clear is Clear with d.mu held.
+func (d *Dev) clear(c image1bit.Bit) error {
+	draw.Draw(d.buffer, d.buffer.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	var b byte
+	if c != image1bit.Bit(d.inverted) {
+		b = 0xFF
+	}
+	if err := d.fillRAM(writeRAMBW, b); err != nil {
+		return err
+	}
+	if d.red != nil {
+		draw.Draw(d.red, d.red.Bounds(), &image.Uniform{image1bit.Off}, image.Point{}, draw.Src)
+		if err := d.fillRAM(writeRAMRed, 0x00); err != nil {
+			return err
+		}
+	}
+	if err := d.update(context.Background()); err != nil {
+		return err
+	}
+	copy(d.shown.Pix, d.buffer.Pix)
+	d.dirty = image.Rectangle{}
+	return nil
+}
+
+// Halt implements conn.Resource. It clears the screen content to white,
+// regardless of SetInverted, and powers the controller off as by PowerOff.
+//
+// The Dev can still be used afterwards; use DeepSleep or ClearAndSleep for
+// the lowest power consumption.
 func (d *Dev) Halt() error {
-	return d.Draw(d.Bounds(), image.White, image.Point{})
+	if err := d.Clear(image1bit.Bit(!d.Inverted())); err != nil {
+		return err
+	}
+	return d.PowerOff()
 }
 
-// Update performs a full display update.
+// Update refreshes the display using the current refresh mode.
 func (d *Dev) Update() error {
-	if err := d.sendCommand(displayUpdateControl2, 0xF7); err != nil {
+	return d.UpdateContext(context.Background())
+}
+
+// UpdateContext is like Update but stops waiting for the refresh to complete
+// when ctx is done, returning ctx.Err(). The refresh itself continues on the
+// panel.
+func (d *Dev) UpdateContext(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.update(ctx)
+}
+
+// update is UpdateContext with d.mu held.
+func (d *Dev) update(ctx context.Context) error {
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.throttle(ctx); err != nil {
+		return err
+	}
+	if d.mode == RefreshPartial && d.FullRefreshEvery > 0 {
+		if d.partials++; d.partials >= d.FullRefreshEvery {
+			return d.fullUpdate(ctx)
+		}
+	}
+	if d.mode == RefreshPartial && d.poweredOff && d.cfg.Controller == SSD1675B {
+		// Partial refreshes expect clock and analog to be enabled.
+		if err := d.powerOn(); err != nil {
+			return err
+		}
+	}
+	if err := d.sendCommand(displayUpdateControl2, d.updateOption(d.mode)); err != nil {
 		return err
 	}
 	if err := d.sendCommand(masterActivation); err != nil {
 		return err
 	}
-	for d.busy.Read() == gpio.High {
-		time.Sleep(10 * time.Millisecond)
+	d.lastRefresh = time.Now()
+	if err := d.waitUntilIdle(ctx, d.mode.String()+" update", d.updateDelay(d.mode)); err != nil {
+		return err
 	}
+	d.refreshed()
+	// Full and fast refreshes disable clock and analog when done.
+	d.poweredOff = d.mode != RefreshPartial
 	return nil
 }
 
-// Init resets and initializes the display.
-func (d *Dev) Init() error {
-	// HW reset
+// throttle waits until MinRefreshInterval has passed since the last refresh.
+func (d *Dev) throttle(ctx context.Context) error {
+	if d.MinRefreshInterval <= 0 || d.lastRefresh.IsZero() {
+		return nil
+	}
+	wait := d.MinRefreshInterval - time.Since(d.lastRefresh)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// refreshed records the completion of the refresh started at d.lastRefresh.
+// A frame written by a draw with AutoRefresh off is displayed from then on.
+func (d *Dev) refreshed() {
+	d.lastDuration = time.Since(d.lastRefresh)
+	if d.OnRefresh != nil {
+		d.OnRefresh(d.lastDuration)
+	}
+	d.lastRefresh = time.Now()
+	if d.written != nil {
+		copy(d.shown.Pix, d.written)
+		if bytes.Equal(d.buffer.Pix, d.written) {
+			d.dirty = image.Rectangle{}
+		}
+		d.written = nil
+	}
+}
+
+// LastRefresh returns when the last refresh completed, or started if it was
+// not waited for, e.g. because its context was done. It is the zero time
+// before the first refresh.
+func (d *Dev) LastRefresh() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastRefresh
+}
+
+// LastRefreshDuration returns how long the last refresh waited for, from
+// activation until the controller was done, as passed to OnRefresh, e.g. to
+// compare refresh modes. It is zero before the first refresh completed, and
+// only reflects the fixed delay waited if the busy line isn't connected.
+func (d *Dev) LastRefreshDuration() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastDuration
+}
+
+// PowerOff disables the clock and the analog blocks, e.g. the booster, of the
+// controller. The displayed image is retained.
+//
+// Unlike DeepSleep, the register settings and RAM are kept and no reset is
+// needed to resume, at the cost of a higher idle current. Full and fast
+// refreshes end in this state anyway; it matters after partial refreshes,
+// which leave the analog blocks enabled.
+func (d *Dev) PowerOff() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(displayUpdateControl2, 0x83); err != nil {
+		return err
+	}
+	if err := d.sendCommand(masterActivation); err != nil {
+		return err
+	}
+	if err := d.waitUntilIdle(context.Background(), "power off", noBusyDelay); err != nil {
+		return err
+	}
+	d.poweredOff = true
+	return nil
+}
+
+// PowerOn enables the clock and the analog blocks disabled by PowerOff.
+//
+// Partial updates call it as needed.
+func (d *Dev) PowerOn() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.powerOn()
+}
+
+// powerOn is PowerOn with d.mu held.
+func (d *Dev) powerOn() error {
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(displayUpdateControl2, 0xC0); err != nil {
+		return err
+	}
+	if err := d.sendCommand(masterActivation); err != nil {
+		return err
+	}
+	if err := d.waitUntilIdle(context.Background(), "power on", noBusyDelay); err != nil {
+		return err
+	}
+	d.poweredOff = false
+	return nil
+}
+
+// Busy reports whether the controller is busy, e.g. refreshing the display.
+// It doesn't block.
+//
+// It always returns false when the busy line isn't connected.
+func (d *Dev) Busy() bool {
+	return d.busy != nil && d.busy.Read() == gpio.High
+}
+
+// SleepMode is a deep sleep mode of the controller.
+type SleepMode byte
+
+// Deep sleep modes.
+const (
+	// DeepSleep1 retains the RAM content.
+	DeepSleep1 SleepMode = 0x01
+	// DeepSleep2 doesn't retain the RAM content and draws slightly less
+	// current.
+	DeepSleep2 SleepMode = 0x03
+)
+
+// DeepSleep puts the controller into deep sleep mode 1, its lowest power
+// state retaining the RAM. The displayed image is retained.
+//
+// The controller only wakes up through a hardware reset, so Init must be
+// called before the display can be used again. Until then, drawing returns
+// ErrSleeping, as does DeepSleep itself; after Close, it returns ErrClosed.
+func (d *Dev) DeepSleep() error {
+	return d.EnterDeepSleep(DeepSleep1)
+}
+
+// EnterDeepSleep is like DeepSleep with the deep sleep mode m.
+//
+// With DeepSleep2 the RAM content is lost, including the base image of
+// partial refreshes, so after Init the whole frame must be written again,
+// e.g. with Refresh or RefreshCurrent, before using DrawPartial or DrawDiff.
+// The displayed image is retained in both modes.
+func (d *Dev) EnterDeepSleep(m SleepMode) error {
+	if m != DeepSleep1 && m != DeepSleep2 {
+		return fmt.Errorf("waveshare213v2: invalid deep sleep mode 0x%02X", byte(m))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if err := d.sendCommand(deepSleepMode, byte(m)); err != nil {
+		return err
+	}
+	d.sleeping = true
+	return nil
+}
+
+// ClearAndSleep clears the display to white, as Halt, then puts the
+// controller into deep sleep, e.g. on shutdown so that the panel doesn't keep
+// showing stale information. Init must be called to use the display again.
+//
+// Deep sleep is entered even if clearing fails; the first error is returned.
+func (d *Dev) ClearAndSleep() error {
+	err := d.Halt()
+	if serr := d.DeepSleep(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Close releases the control pins, e.g. for another process to use them, by
+// setting dc and rst as floating inputs where they support it. The SPI
+// connection and the settings are kept, and Init drives the pins again.
+// Until then, drawing returns ErrClosed.
+//
+// Unlike Halt, Close doesn't change the displayed image. Use ClearAndSleep or
+// DeepSleep first, as the controller may be reset randomly with a floating
+// reset line.
+func (d *Dev) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, p := range []gpio.PinOut{d.dc, d.rst} {
+		if in, ok := p.(gpio.PinIn); ok {
+			if err := in.In(gpio.Float, gpio.NoEdge); err != nil {
+				return fmt.Errorf("waveshare213v2: releasing %s: %w", p, err)
+			}
+		}
+	}
+	d.sleeping = true
+	d.closed = true
+	return nil
+}
+
+// sleepingErr returns the error returned when accessing the display while
+// sleeping is set.
+func (d *Dev) sleepingErr() error {
+	if d.closed {
+		return ErrClosed
+	}
+	return ErrSleeping
+}
+
+// Sleeping reports whether the controller is in deep sleep.
+func (d *Dev) Sleeping() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sleeping
+}
+
+// Reset performs a hardware reset of the controller, which also wakes it up
+// from deep sleep. The displayed image is kept.
+//
+// The controller is left unconfigured; Init calls Reset before sending the
+// initialization sequence.
+func (d *Dev) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reset()
+}
+
+// reset is Reset with d.mu held.
+func (d *Dev) reset() error {
 	if err := d.rst.Out(gpio.High); err != nil {
 		return err
 	}
-	time.Sleep(20 * time.Millisecond)
+	time.Sleep(d.ResetTiming.High)
 	if err := d.rst.Out(gpio.Low); err != nil {
 		return err
 	}
-	time.Sleep(20 * time.Millisecond)
+	time.Sleep(d.ResetTiming.Low)
 	if err := d.rst.Out(gpio.High); err != nil {
 		return err
 	}
-	time.Sleep(200 * time.Millisecond)
+	time.Sleep(d.ResetTiming.Settle)
+	d.mode = RefreshFull
+	d.sleeping = false
+	d.closed = false
+	d.poweredOff = true
+	return nil
+}
+
+// Init resets and initializes the display. It may be called any number of
+// times, e.g. to wake up from deep sleep or recover from an error. The frame
+// buffer and the displayed image are kept.
+//
+// Init doesn't refresh the display, so the image retained by the panel, e.g.
+// from before a restart, survives Init and the constructors until the next
+// refresh, by a Draw or Clear for instance. The frame buffer of a new Dev is
+// white whatever is displayed.
+//
+// If it fails, the controller is held in reset and drawing returns
+// ErrSleeping until Init succeeds.
+func (d *Dev) Init() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.initialize()
+}
+
+// initialize is Init with d.mu held.
+func (d *Dev) initialize() (err error) {
+	defer func() {
+		if err != nil {
+			d.abortInit()
+		}
+	}()
+	if err := d.reset(); err != nil {
+		return err
+	}
 
 	// SW reset
 	if err := d.sendCommand(swReset); err != nil {
 		return err
 	}
 	time.Sleep(10 * time.Millisecond)
+	if err := d.waitUntilIdle(context.Background(), "reset", noBusyDelay); err != nil {
+		return fmt.Errorf("%w; the controller may need a longer ResetTiming", err)
+	}
 
 	// Send initialization code
-	if err := d.sendCommand(driverOutputControl, byte((d.Bounds().Dy()-1)&0xFF), byte(((d.Bounds().Dy()-1)>>8)&0xFF), 0x00); err != nil {
-		return err
+	for _, c := range d.initSequence() {
+		if err := d.sendCommand(c.cmd, c.data...); err != nil {
+			return err
+		}
 	}
-	if err := d.sendCommand(dataEntryModeSetting, 0x01); err != nil {
-		return err
+	return d.setWindow(d.ramWindow(d.panelBounds()))
+}
+
+// abortInit holds the controller in reset after a failed Init, so that it
+// doesn't drive the panel in a half configured state. As after DeepSleep,
+// Init must be called before the display can be used again.
+func (d *Dev) abortInit() {
+	// The Init error is more relevant than one from here.
+	_ = d.rst.Out(gpio.Low)
+	d.sleeping = true
+}
+
+// initCommand is a step of the initialization sequence.
+type initCommand struct {
+	cmd  byte
+	data []byte
+	// skip removes the command from the sequence, for WithoutInitCommand.
+	skip bool
+}
+
+// initSequence returns the commands sent by Init after the software reset,
+// with the overrides set by WithInitCommand and WithoutInitCommand applied.
+func (d *Dev) initSequence() []initCommand {
+	seq := d.gateInit()
+	seq = append(seq,
+		initCommand{cmd: dataEntryModeSetting, data: []byte{byte(d.entry)}},
+		initCommand{cmd: borderWaveformControl, data: []byte{byte(d.border)}},
+	)
+	seq = append(seq, d.temperatureInit()...)
+	if d.cfg.Controller == SSD1680 {
+		// Source output mode S8 to S167.
+		seq = append(seq, initCommand{cmd: displayUpdateControl1, data: []byte{d.ramOptions, 0x80}})
+	} else if d.ramOptions != 0 {
+		seq = append(seq, initCommand{cmd: displayUpdateControl1, data: []byte{d.ramOptions}})
 	}
-	if err := d.sendCommand(setRAMXAddressStartEndPosition, 0x00, 0x0F); err != nil { //0x0F-->(15+1)*8=128
-		return err
+	seq = append(seq, d.voltages.initCommands()...)
+	for _, o := range d.initOverrides {
+		i := 0
+		for i < len(seq) && seq[i].cmd != o.cmd {
+			i++
+		}
+		switch {
+		case i == len(seq):
+			if !o.skip {
+				seq = append(seq, o)
+			}
+		case o.skip:
+			seq = append(seq[:i], seq[i+1:]...)
+		default:
+			seq[i] = o
+		}
 	}
-	if err := d.sendCommand(setRAMYAddressStartEndPosition, 0xF9, 0x00, 0x00, 0x00); err != nil { //0xF9-->(249+1)=250
-		return err
+	return seq
+}
+
+// EncodeImage returns the RAM content, as written to the controller, for a
+// frame showing img in dstRect on a white background. img is aligned on its
+// bounds' top left corner; rotation and mirroring apply as in Draw.
+//
+// Each row of the panel is encoded as (Width+7)/8 bytes, most significant bit
+// first, a set bit being white. With the default data entry mode, pixel
+// column x is stored in RAM column Width-1-x, so the padding bits of the last
+// byte of each row are off-screen and always zero. Rows are stored from the
+// top of the panel. The bits are flipped when SetInverted is on. A nil img
+// encodes a white frame.
+func (d *Dev) EncodeImage(img image.Image, dstRect image.Rectangle) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	frame := image1bit.NewVerticalLSB(d.panelBounds())
+	draw.Draw(frame, frame.Bounds(), image.White, image.Point{}, draw.Src)
+	if img != nil {
+		r, sp := d.clip(dstRect, img.Bounds().Min)
+		draw.Draw(&view{d, frame}, r, img, sp, draw.Over)
+	}
+	return d.encodeRAM(frame, frame.Bounds(), d.inverted)
+}
+
+// writeRAM writes the pixels of img within r, in panel coordinates, to the
+// RAM selected by cmd, flipping the bits if invert is set. It returns the
+// number of data bytes written.
+func (d *Dev) writeRAM(cmd byte, img *image1bit.VerticalLSB, r image.Rectangle, invert bool) (int, error) {
+	xStart, xEnd, yStart, yEnd := d.ramWindow(r)
+	data := d.encodeRAM(img, r, invert)
+	err := d.retry(func() error {
+		if err := d.setWindow(xStart, xEnd, yStart, yEnd); err != nil {
+			return err
+		}
+		if err := d.sendCommand(cmd); err != nil {
+			return err
+		}
+		if err := d.sendData(data...); err != nil {
+			return fmt.Errorf("waveshare213v2: writing %d bytes to RAM 0x%02X for rows %d-%d: %w", len(data), cmd, r.Min.Y, r.Max.Y-1, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// ramWindow returns the RAM window covering r, in panel coordinates, in the
+// order set by the data entry mode. x is in bytes, y in gate lines; start and
+// end are inclusive.
+//
+// With the default DataEntryXIncYDec, the image is mirrored horizontally in
+// RAM: pixel column x is stored in RAM column Width-1-x, so that column 0
+// lands flush against the visible edge and the padding bits of the last RAM
+// byte are off-screen. Rows are written with a decrementing Y address
+// counter. The window is widened to whole RAM bytes.
+func (d *Dev) ramWindow(r image.Rectangle) (xStart, xEnd, yStart, yEnd int) {
+	w, h := d.cfg.Width, d.cfg.Height
+	if d.entry&entryXInc != 0 {
+		xStart, xEnd = (w-r.Max.X)/8, (w-1-r.Min.X)/8
+	} else {
+		xStart, xEnd = (r.Max.X-1)/8, r.Min.X/8
+	}
+	if d.entry&entryYInc != 0 {
+		yStart, yEnd = r.Min.Y, r.Max.Y-1
+	} else {
+		yStart, yEnd = h-1-r.Min.Y, h-r.Max.Y
+	}
+	return xStart, xEnd, yStart, yEnd
+}
+
+// ramRect returns r, in panel coordinates, widened to whole RAM bytes and
+// clipped to the panel.
+func (d *Dev) ramRect(r image.Rectangle) image.Rectangle {
+	xStart, xEnd, _, _ := d.ramWindow(r)
+	if xStart > xEnd {
+		xStart, xEnd = xEnd, xStart
+	}
+	x0, x1 := xStart*8, xEnd*8+8
+	if d.entry&entryXInc != 0 {
+		w := d.cfg.Width
+		x0, x1 = w-x1, w-x0
+	}
+	return image.Rect(x0, r.Min.Y, x1, r.Max.Y).Intersect(d.panelBounds())
+}
+
+// encodeRAM returns the RAM bytes for the window covering r, in the order
+// they are written. The pixels are flipped if invert is set; padding bits are
+// always zero.
+//
+// img must have the panel bounds. Pixels are read directly from img.Pix, where
+// each byte holds a column of 8 rows, instead of calling BitAt for each one.
+func (d *Dev) encodeRAM(img *image1bit.VerticalLSB, r image.Rectangle, invert bool) []byte {
+	w := d.cfg.Width
+	xStart, xEnd, _, _ := d.ramWindow(r)
+	step := 1
+	if xStart > xEnd {
+		step = -1
+	}
+	stride := (xEnd-xStart)*step + 1
+	// Pixel column of RAM column 0, and the direction to the next RAM column.
+	x0, dx := 0, 1
+	if d.entry&entryXInc != 0 {
+		x0, dx = w-1, -1
+	}
+	data := make([]byte, stride*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		band := img.Pix[y/8*img.Stride : y/8*img.Stride+w]
+		mask := byte(1) << uint(y&7)
+		row := data[(y-r.Min.Y)*stride:]
+		for i := 0; i < stride; i++ {
+			// Pixel column of the most significant bit.
+			x := x0 + (xStart+i*step)*8*dx
+			var b byte
+			for bit := 0; bit < 8; bit, x = bit+1, x+dx {
+				if x >= 0 && x < w && (band[x]&mask != 0) != invert {
+					b |= 0x80 >> uint(bit)
+				}
+			}
+			row[i] = b
+		}
+	}
+	return data
+}
+
+// decodeRAM sets img, with the panel bounds, from the RAM bytes of the whole
+// panel as returned by encodeRAM.
+func (d *Dev) decodeRAM(img *image1bit.VerticalLSB, data []byte, invert bool) {
+	for y := 0; y < d.cfg.Height; y++ {
+		for x := 0; x < d.cfg.Width; x++ {
+			i, mask := d.ramBit(image.Pt(x, y))
+			on := data[i]&mask != 0
+			img.SetBit(x, y, image1bit.Bit(on != invert))
+		}
+	}
+}
+
+// ramBit returns the index in the RAM bytes of the whole panel, as returned
+// by encodeRAM, and the mask of the bit of p, in panel coordinates.
+func (d *Dev) ramBit(p image.Point) (int, byte) {
+	stride := d.cfg.stride()
+	rx := p.X
+	if d.entry&entryXInc != 0 {
+		rx = d.cfg.Width - 1 - p.X
+	}
+	// With X decrementing, RAM is written from the last byte.
+	i := rx / 8
+	if d.entry&entryXInc == 0 {
+		i = stride - 1 - i
+	}
+	return p.Y*stride + i, 0x80 >> uint(rx&7)
+}
+
+// fillRAM fills the whole RAM selected by cmd with b.
+func (d *Dev) fillRAM(cmd byte, b byte) error {
+	data := make([]byte, d.FrameSize())
+	for i := range data {
+		data[i] = b
+	}
+	return d.retry(func() error {
+		if err := d.setWindow(d.ramWindow(d.panelBounds())); err != nil {
+			return err
+		}
+		if err := d.sendCommand(cmd); err != nil {
+			return err
+		}
+		if err := d.sendData(data...); err != nil {
+			return fmt.Errorf("waveshare213v2: filling RAM 0x%02X: %w", cmd, err)
+		}
+		return nil
+	})
+}
+
+// SetWindow sets the RAM window subsequent RAM writes go to, and moves the
+// address counters to its start, from the rectangle (x0, y0)-(x1, y1) in
+// panel coordinates, i.e. ignoring rotation and mirroring. x1 and y1 are
+// exclusive.
+//
+// RAM is addressed in bytes of 8 pixels horizontally. With the default data
+// entry mode the image is mirrored in RAM, so byte boundaries are at Width
+// minus multiples of 8, otherwise at multiples of 8; x0 and x1 must be 0,
+// Width or such a boundary.
+func (d *Dev) SetWindow(x0, y0, x1, y1 int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	r := image.Rect(x0, y0, x1, y1)
+	if r.Empty() || !r.In(d.panelBounds()) {
+		return fmt.Errorf("waveshare213v2: window %v is empty or out of %v", r, d.panelBounds())
+	}
+	w := d.cfg.Width
+	aligned := func(x int) bool {
+		if x == 0 || x == w {
+			return true
+		}
+		if d.entry&entryXInc != 0 {
+			return (w-x)%8 == 0
+		}
+		return x%8 == 0
 	}
-	if err := d.sendCommand(borderWaveformControl, 0x01); err != nil {
+	if !aligned(r.Min.X) || !aligned(r.Max.X) {
+		return fmt.Errorf("waveshare213v2: window %v is not aligned on RAM bytes", r)
+	}
+	return d.setWindow(d.ramWindow(r))
+}
+
+// SetRAMXWindow sets the RAM X window, in RAM bytes, from start to end
+// inclusive, and moves the X address counter to start. The Y window is left
+// as is. start is larger than end for data entry modes decrementing X.
+//
+// The 122 pixel rows of the 2.13inch panel take 16 bytes, 0 to 15, whose 128
+// bits include 6 columns beyond the panel. With the default data entry mode,
+// pixel column x is RAM column 121-x: pixel 121 is the most significant bit of
+// byte 0, pixel 0 bit 0x40 of byte 15, and the 6 lowest bits of byte 15 are
+// the off-screen padding. The driver always writes these bits as zero and
+// never reads them: a window ending at byte 15 is needed to reach pixels 0
+// and 1, and writing its padding is harmless.
+func (d *Dev) SetRAMXWindow(start, end int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	n := d.cfg.stride()
+	if start < 0 || start >= n || end < 0 || end >= n {
+		return fmt.Errorf("waveshare213v2: RAM X window %d-%d is out of 0-%d", start, end, n-1)
+	}
+	if err := d.sendCommand(setRAMXAddressStartEndPosition, byte(start), byte(end)); err != nil {
 		return err
 	}
-	if err := d.sendCommand(temperatureSensorControl, 0x80); err != nil {
+	return d.sendCommand(setRAMXAddressCounter, byte(start))
+}
+
+// SetCursor moves the RAM address counters to the RAM byte holding the pixel
+// (x, y), in panel coordinates, without changing the window. Subsequent RAM
+// data is written from there.
+//
+// The counters are moved to the start of the window by SetWindow and before
+// every RAM write done by Dev, so this is only needed when writing RAM
+// directly, e.g. to write several frames to the same window.
+func (d *Dev) SetCursor(x, y int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sleeping {
+		return d.sleepingErr()
+	}
+	if !image.Pt(x, y).In(d.panelBounds()) {
+		return fmt.Errorf("waveshare213v2: cursor (%d, %d) is out of %v", x, y, d.panelBounds())
+	}
+	xStart, _, yStart, _ := d.ramWindow(image.Rect(x, y, x+1, y+1))
+	return d.setCursor(xStart, yStart)
+}
+
+// setWindow sets the RAM window and moves the address counters to its start.
+// x is in bytes, y in gate lines; start and end are inclusive. y is sent as
+// 16 bits, low byte first, so that panels of more than 256 lines work: line
+// 249, the last of a 250 line panel, is sent as 0xF9 0x00 and line 295, the
+// last of a 296 line one, as 0x27 0x01.
+func (d *Dev) setWindow(xStart, xEnd, yStart, yEnd int) error {
+	if err := d.sendCommand(setRAMXAddressStartEndPosition, byte(xStart), byte(xEnd)); err != nil {
 		return err
 	}
-	if err := d.sendCommand(setRAMXAddressCounter, 0x00); err != nil {
+	if err := d.sendCommand(setRAMYAddressStartEndPosition, byte(yStart), byte(yStart>>8), byte(yEnd), byte(yEnd>>8)); err != nil {
 		return err
 	}
-	if err := d.sendCommand(setRAMYAddressCounter, 0xF9, 0x00); err != nil {
+	return d.setCursor(xStart, yStart)
+}
+
+// setCursor sets the RAM address counters. x is in bytes, y in gate lines.
+func (d *Dev) setCursor(x, y int) error {
+	if err := d.sendCommand(setRAMXAddressCounter, byte(x)); err != nil {
 		return err
 	}
+	return d.sendCommand(setRAMYAddressCounter, byte(y), byte(y>>8))
+}
+
+// WaitUntilIdle blocks until the controller releases the busy line,
+// BusyTimeout expires or ctx is done, in which case ctx.Err() is returned.
+//
+// Refreshes started with UpdateContext or DrawContext keep running when their
+// context is done; WaitUntilIdle waits for them to complete. It doesn't block
+// other operations and returns immediately when the busy line isn't
+// connected.
+func (d *Dev) WaitUntilIdle(ctx context.Context) error {
+	if d.busy == nil {
+		return nil
+	}
+	return d.waitUntilIdle(ctx, "WaitUntilIdle", 0)
+}
 
+// waitUntilIdle polls the busy line until the controller is done with op,
+// BusyTimeout expires or ctx is done.
+//
+// Without a busy line, it waits for delay instead, which must be a
+// conservative estimate of the duration of op.
+func (d *Dev) waitUntilIdle(ctx context.Context, op string, delay time.Duration) error {
+	if d.busy == nil {
+		if d.Logger != nil {
+			d.Logger.Printf("waveshare213v2: %s: waiting %s", op, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			return nil
+		}
+	}
+	start := time.Now()
+	// Poll often at first, for short operations, then back off to limit
+	// wakeups during refreshes.
+	interval := minBusyPoll
+	for d.busy.Read() == gpio.High {
+		if d.BusyTimeout > 0 && time.Since(start) > d.BusyTimeout {
+			return fmt.Errorf("waveshare213v2: timeout waiting for busy line after %s", op)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxBusyPoll {
+			interval = maxBusyPoll
+		}
+	}
+	if d.Logger != nil {
+		d.Logger.Printf("waveshare213v2: %s: busy for %s", op, time.Since(start))
+	}
 	return nil
 }
 
+// sendCommand sends command and its parameters. Errors are annotated with
+// the command.
 func (d *Dev) sendCommand(command byte, data ...byte) error {
-	if err := d.dc.Out(gpio.Low); err != nil {
-		return err
+	if d.Logger != nil {
+		d.logCommand(command, data)
 	}
-	if err := d.conn.Tx([]byte{command}, nil); err != nil {
+	return d.retry(func() error {
+		if err := d.dc.Out(gpio.Low); err != nil {
+			return fmt.Errorf("waveshare213v2: command 0x%02X: %w", command, err)
+		}
+		if err := d.conn.Tx([]byte{command}, nil); err != nil {
+			return fmt.Errorf("waveshare213v2: command 0x%02X: %w", command, err)
+		}
+		if len(data) != 0 {
+			if err := d.sendData(data...); err != nil {
+				return fmt.Errorf("waveshare213v2: command 0x%02X: %w", command, err)
+			}
+		}
+		return nil
+	})
+}
+
+// logCommand logs command and the start of its parameters.
+func (d *Dev) logCommand(command byte, data []byte) {
+	const max = 8
+	if len(data) > max {
+		d.Logger.Printf("waveshare213v2: command 0x%02X % X ... (%d bytes)", command, data[:max], len(data))
+		return
+	}
+	d.Logger.Printf("waveshare213v2: command 0x%02X % X", command, data)
+}
+
+// retry runs op, retrying it up to MaxRetries times with an exponential
+// backoff while it fails. op must be safe to repeat from the start: a RAM
+// write must set the window again, as the address counters moved.
+//
+// Nested calls run op once, so only the outermost operation is retried.
+func (d *Dev) retry(op func() error) error {
+	if d.MaxRetries <= 0 || d.retrying {
+		return op()
+	}
+	d.retrying = true
+	defer func() { d.retrying = false }()
+	backoff := d.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for i := 0; ; i++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if i == d.MaxRetries {
+			return fmt.Errorf("waveshare213v2: failed after %d retries: %w", i, err)
+		}
+		if d.Logger != nil {
+			d.Logger.Printf("waveshare213v2: retrying after %s: %v", backoff, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendData sends data in transfers of at most maxTxSize bytes, keeping dc
+// high in between.
+func (d *Dev) sendData(data ...byte) error {
+	if err := d.dc.Out(gpio.High); err != nil {
 		return err
 	}
-	if len(data) != 0 {
-		if err := d.sendData(data...); err != nil {
+	n := len(data)
+	max := d.maxTxSize()
+	for len(data) > max {
+		if err := d.conn.Tx(data[:max], nil); err != nil {
 			return err
 		}
+		data = data[max:]
+	}
+	if err := d.conn.Tx(data, nil); err != nil {
+		return err
+	}
+	if d.OnWrite != nil {
+		d.OnWrite(n)
 	}
 	return nil
 }
 
-func (d *Dev) sendData(data ...byte) error {
-	packets := make([]spi.Packet, len(data))
-	for i := range data {
-		packets[i] = spi.Packet{W: []byte{data[i]}}
+// maxTxSize returns the maximum number of bytes of a single SPI transfer.
+func (d *Dev) maxTxSize() int {
+	max := d.MaxTxSize
+	if l, ok := d.conn.(conn.Limits); ok {
+		if m := l.MaxTxSize(); m > 0 && (max <= 0 || m < max) {
+			max = m
+		}
 	}
-	if err := d.dc.Out(gpio.High); err != nil {
-		return err
+	if max <= 0 {
+		return int(^uint(0) >> 1)
 	}
-	return d.conn.TxPackets(packets)
+	return max
 }
 
 var _ display.Drawer = &Dev{}
@@ -0,0 +1,861 @@
+// Copyright 2019 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package waveshare213v2
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+
+	"periph.io/x/periph/conn/conntest"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+// fakeConn is a spi.Conn recording the transfers along with the level of the
+// dc pin, to tell commands from data.
+type fakeConn struct {
+	conntest.Record
+	dc  *gpiotest.Pin
+	dcs []gpio.Level
+	// max is returned by MaxTxSize.
+	max int
+}
+
+func (f *fakeConn) Tx(w, r []byte) error {
+	if err := f.Record.Tx(w, r); err != nil {
+		return err
+	}
+	f.dcs = append(f.dcs, f.dc.Read())
+	return nil
+}
+
+func (f *fakeConn) TxPackets(p []spi.Packet) error {
+	return conntest.Errorf("fakeConn: TxPackets is not implemented")
+}
+
+func (f *fakeConn) MaxTxSize() int {
+	return f.max
+}
+
+// command is a command sent to the controller, with its data.
+type command struct {
+	cmd  byte
+	data []byte
+}
+
+// commands returns the commands sent since the last reset, data sent in
+// several transfers being joined.
+func (f *fakeConn) commands(t *testing.T) []command {
+	var c []command
+	for i, op := range f.Ops {
+		if f.dcs[i] == gpio.Low {
+			if len(op.W) != 1 {
+				t.Fatalf("command transfer of %d bytes: % X", len(op.W), op.W)
+			}
+			c = append(c, command{cmd: op.W[0]})
+			continue
+		}
+		if len(c) == 0 {
+			t.Fatalf("data without command: % X", op.W)
+		}
+		c[len(c)-1].data = append(c[len(c)-1].data, op.W...)
+	}
+	return c
+}
+
+// find returns the commands cmd sent since the last reset.
+func (f *fakeConn) find(t *testing.T, cmd byte) []command {
+	var out []command
+	for _, c := range f.commands(t) {
+		if c.cmd == cmd {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// reset forgets the transfers recorded so far.
+func (f *fakeConn) reset() {
+	f.Ops = nil
+	f.dcs = nil
+}
+
+// ramSim simulates the black and white RAM of the controller and its address
+// counters, to check what RAM writes actually change.
+type ramSim struct {
+	entry          DataEntryMode
+	x0, x1, y0, y1 int
+	x, y           int
+	ram            map[image.Point]byte
+}
+
+func newRAMSim(entry DataEntryMode) *ramSim {
+	return &ramSim{entry: entry, ram: map[image.Point]byte{}}
+}
+
+// apply runs cmds on the simulated RAM.
+func (s *ramSim) apply(t *testing.T, cmds []command) {
+	t.Helper()
+	for _, c := range cmds {
+		switch c.cmd {
+		case dataEntryModeSetting:
+			s.entry = DataEntryMode(c.data[0])
+		case setRAMXAddressStartEndPosition:
+			s.x0, s.x1 = int(c.data[0]), int(c.data[1])
+		case setRAMYAddressStartEndPosition:
+			s.y0, s.y1 = int(c.data[0])|int(c.data[1])<<8, int(c.data[2])|int(c.data[3])<<8
+		case setRAMXAddressCounter:
+			s.x = int(c.data[0])
+		case setRAMYAddressCounter:
+			s.y = int(c.data[0]) | int(c.data[1])<<8
+		case writeRAMBW:
+			if len(c.data) == 0 {
+				t.Fatal("RAM write without data")
+			}
+			for _, b := range c.data {
+				s.ram[image.Pt(s.x, s.y)] = b
+				s.next()
+			}
+		}
+	}
+}
+
+// next moves the address counters to the next byte, X first.
+func (s *ramSim) next() {
+	if s.x != s.x1 {
+		if s.entry&entryXInc != 0 {
+			s.x++
+		} else {
+			s.x--
+		}
+		return
+	}
+	s.x = s.x0
+	if s.entry&entryYInc != 0 {
+		s.y++
+	} else {
+		s.y--
+	}
+}
+
+// checkRAM checks that sim holds the frame buffer of d, as written by a full
+// frame write.
+func checkRAM(t *testing.T, d *Dev, c *fakeConn, sim *ramSim) {
+	t.Helper()
+	c.reset()
+	if _, err := d.writeRAM(writeRAMBW, d.buffer, d.buffer.Bounds(), d.inverted); err != nil {
+		t.Fatal(err)
+	}
+	want := newRAMSim(d.entry)
+	want.apply(t, c.commands(t))
+	c.reset()
+	for p, b := range want.ram {
+		if got := sim.ram[p]; got != b {
+			t.Fatalf("RAM byte %v is %#02x, want %#02x", p, got, b)
+		}
+	}
+}
+
+// newTestDev returns an initialized Dev for cfg using fakes, with the reset
+// and refresh delays disabled.
+func newTestDev(t *testing.T, cfg Config, opts ...Option) (*Dev, *fakeConn) {
+	dc := &gpiotest.Pin{N: "DC"}
+	c := &fakeConn{dc: dc}
+	d, err := NewConn(c, cfg, dc, &gpiotest.Pin{N: "RST"}, &gpiotest.Pin{N: "BUSY", L: gpio.Low}, append(opts, WithoutInit())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.ResetTiming = ResetTiming{}
+	d.MinRefreshInterval = 0
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c.reset()
+	return d, c
+}
+
+func TestNewConn(t *testing.T) {
+	dc := &gpiotest.Pin{N: "DC"}
+	c := &fakeConn{dc: dc}
+	d, err := NewConn(c, EPD2in13V2, dc, &gpiotest.Pin{N: "RST"}, &gpiotest.Pin{N: "BUSY"}, WithoutInit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Ops) != 0 {
+		t.Fatalf("WithoutInit sent % X", c.Ops)
+	}
+	d.ResetTiming = ResetTiming{}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cmds := c.commands(t)
+	if len(cmds) == 0 || cmds[0].cmd != swReset {
+		t.Fatalf("Init doesn't start with a software reset: %v", cmds)
+	}
+	if got := c.find(t, driverOutputControl); len(got) != 1 || !bytes.Equal(got[0].data, []byte{0xF9, 0x00, 0x00}) {
+		t.Fatalf("driver output control: %v", got)
+	}
+}
+
+func TestNewConnPins(t *testing.T) {
+	dc := &gpiotest.Pin{N: "DC"}
+	c := &fakeConn{dc: dc}
+	if _, err := NewConn(c, EPD2in13V2, dc, &gpiotest.Pin{N: "DC"}, nil, WithoutInit()); err == nil {
+		t.Fatal("expected an error for dc and rst on the same pin")
+	}
+	if _, err := NewConn(c, EPD2in13V2, nil, &gpiotest.Pin{N: "RST"}, nil, WithoutInit()); err == nil {
+		t.Fatal("expected an error without dc")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	want := []command{{cmd: displayUpdateControl2, data: []byte{0xF7}}, {cmd: masterActivation}}
+	if got := c.commands(t); !equalCommands(got, want) {
+		t.Fatalf("Update sent %v, want %v", got, want)
+	}
+}
+
+func equalCommands(a, b []command) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].cmd != b[i].cmd || !bytes.Equal(a[i].data, b[i].data) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDrawEdges(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	img := image1bit.NewVerticalLSB(d.Bounds())
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < 250; y++ {
+		img.SetBit(0, y, image1bit.Off)
+		img.SetBit(121, y, image1bit.Off)
+	}
+	if err := d.Draw(d.Bounds(), img, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	w := c.find(t, writeRAMBW)
+	if len(w) != 1 || len(w[0].data) != 16*250 {
+		t.Fatalf("RAM writes: %v", w)
+	}
+	// Pixel 121 is the most significant bit of the first byte of each row,
+	// pixel 0 the second most significant bit of the last one.
+	row := bytes.Repeat([]byte{0xFF}, 16)
+	row[0], row[15] = 0x7F, 0x80
+	for y := 0; y < 250; y++ {
+		if got := w[0].data[y*16 : y*16+16]; !bytes.Equal(got, row) {
+			t.Fatalf("row %d: % X, want % X", y, got, row)
+		}
+	}
+}
+
+// discardConn is a spi.Conn discarding everything, for benchmarks.
+type discardConn struct {
+	conntest.Discard
+}
+
+func (d *discardConn) TxPackets(p []spi.Packet) error {
+	return nil
+}
+
+func newBenchDev(b *testing.B) *Dev {
+	d, err := NewConn(&discardConn{}, EPD2in13V2, &gpiotest.Pin{N: "DC"}, &gpiotest.Pin{N: "RST"}, nil, WithoutInit())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return d
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	d := newBenchDev(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := d.writeFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSendDataSingleTransfer(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	if err := d.writeFrame(); err != nil {
+		t.Fatal(err)
+	}
+	last := c.Ops[len(c.Ops)-1]
+	if len(last.W) != d.FrameSize() || c.dcs[len(c.Ops)-1] != gpio.High {
+		t.Fatalf("frame sent in transfers of %d bytes", len(last.W))
+	}
+}
+
+func TestDrawClip(t *testing.T) {
+	d, _ := newTestDev(t, EPD2in13V2)
+	black := &image.Uniform{image1bit.Off}
+	if err := d.Draw(image.Rect(-100, -100, 1000, 1000), black, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, d.Snapshot(), d.Bounds())
+
+	d, _ = newTestDev(t, EPD2in13V2)
+	src := image1bit.NewVerticalLSB(image.Rect(0, 0, 20, 20))
+	if err := d.Draw(image.Rect(-10, 240, 10, 260), src, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, d.Snapshot(), image.Rect(0, 240, 10, 250))
+}
+
+// checkFrame checks that img is black within r and white elsewhere.
+func checkFrame(t *testing.T, img *image1bit.VerticalLSB, r image.Rectangle) {
+	t.Helper()
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			want := image1bit.Bit(!image.Pt(x, y).In(r))
+			if got := img.BitAt(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeRAM(t *testing.T) {
+	d, _ := newTestDev(t, EPD2in13V2)
+	img := image1bit.NewVerticalLSB(d.panelBounds())
+	for i := range img.Pix {
+		img.Pix[i] = byte(i*7 + i>>3)
+	}
+	for _, m := range []DataEntryMode{DataEntryXDecYDec, DataEntryXIncYDec, DataEntryXDecYInc, DataEntryXIncYInc} {
+		d.entry = m
+		want := make([]byte, d.FrameSize())
+		for y := 0; y < 250; y++ {
+			for x := 0; x < 122; x++ {
+				if img.BitAt(x, y) == image1bit.On {
+					i, mask := d.ramBit(image.Pt(x, y))
+					want[i] |= mask
+				}
+			}
+		}
+		if got := d.encodeRAM(img, img.Bounds(), false); !bytes.Equal(got, want) {
+			t.Errorf("%#x: encodeRAM doesn't match BitAt", m)
+		}
+	}
+}
+
+func BenchmarkEncodeRAM(b *testing.B) {
+	d := newBenchDev(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.encodeRAM(d.buffer, d.buffer.Bounds(), false)
+	}
+}
+
+func TestSetCursor(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	for _, tc := range []struct {
+		x, y int
+		want []command
+	}{
+		{0, 0, []command{{setRAMXAddressCounter, []byte{15}}, {setRAMYAddressCounter, []byte{0xF9, 0x00}}}},
+		{121, 249, []command{{setRAMXAddressCounter, []byte{0}}, {setRAMYAddressCounter, []byte{0x00, 0x00}}}},
+		{8, 100, []command{{setRAMXAddressCounter, []byte{14}}, {setRAMYAddressCounter, []byte{0x95, 0x00}}}},
+	} {
+		c.reset()
+		if err := d.SetCursor(tc.x, tc.y); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.commands(t); !equalCommands(got, tc.want) {
+			t.Errorf("SetCursor(%d, %d) sent %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+	if err := d.SetCursor(122, 0); err == nil {
+		t.Error("expected an error out of the panel")
+	}
+}
+
+func TestRAMWriteMovesCursor(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	for i := 0; i < 2; i++ {
+		c.reset()
+		if err := d.DrawPartial(image.Rect(16, 50, 32, 60), image.Black, image.Point{}); err != nil {
+			t.Fatal(err)
+		}
+		cmds := c.commands(t)
+		want := []command{
+			{setRAMXAddressStartEndPosition, []byte{11, 13}},
+			{setRAMYAddressStartEndPosition, []byte{0xC7, 0x00, 0xBE, 0x00}},
+			{setRAMXAddressCounter, []byte{11}},
+			{setRAMYAddressCounter, []byte{0xC7, 0x00}},
+		}
+		if len(cmds) < 5 || !equalCommands(cmds[:4], want) || cmds[4].cmd != writeRAMBW {
+			t.Fatalf("DrawPartial sent %v, want %v before the RAM write", cmds, want)
+		}
+	}
+}
+
+func TestSendDataChunks(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		maxTxSize  int
+		connLimit  int
+		wantChunks []int
+	}{
+		{"MaxTxSize", 1000, 0, []int{1000, 1000, 1000, 1000}},
+		{"uneven", 1500, 0, []int{1500, 1500, 1000}},
+		{"conn", 0, 3000, []int{3000, 1000}},
+		{"smallest", 3000, 1500, []int{1500, 1500, 1000}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, c := newTestDev(t, EPD2in13V2)
+			d.MaxTxSize = tc.maxTxSize
+			c.max = tc.connLimit
+			if err := d.fillRAM(writeRAMBW, 0xFF); err != nil {
+				t.Fatal(err)
+			}
+			// The data transfers after the RAM write command.
+			i := len(c.Ops) - len(tc.wantChunks)
+			if op := c.Ops[i-1]; c.dcs[i-1] != gpio.Low || !bytes.Equal(op.W, []byte{writeRAMBW}) {
+				t.Fatalf("unexpected transfers % X", c.Ops)
+			}
+			for j, n := range tc.wantChunks {
+				if got := len(c.Ops[i+j].W); got != n || c.dcs[i+j] != gpio.High {
+					t.Fatalf("chunk %d: %d bytes with dc %v, want %d bytes with dc high", j, got, c.dcs[i+j], n)
+				}
+			}
+			if w := c.find(t, writeRAMBW); len(w) != 1 || len(w[0].data) != 4000 {
+				t.Fatalf("RAM writes: %v", w)
+			}
+		})
+	}
+}
+
+func TestDrawTransparent(t *testing.T) {
+	d, _ := newTestDev(t, EPD2in13V2)
+	d.DrawBuffer(d.Bounds(), image.Black, image.Point{})
+	// Opaque black on the left half, transparent on the right one.
+	logo := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			logo.SetNRGBA(x, y, color.NRGBA{A: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatal(err)
+	}
+	src, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Draw(image.Rect(0, 0, 40, 20), src, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	img := d.Snapshot()
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			want := image1bit.Bit(x >= 20)
+			if got := img.BitAt(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if img.BitAt(50, 10) != image1bit.Off {
+		t.Fatal("pixels outside dstRect changed")
+	}
+}
+
+// grayGradient returns a gray image of size w x h with a diagonal gradient.
+func grayGradient(w, h int) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			g.SetGray(x, y, color.Gray{Y: uint8((x + y) * 3)})
+		}
+	}
+	return g
+}
+
+func TestDrawGray(t *testing.T) {
+	g := grayGradient(300, 300)
+	for _, r := range []Rotation{Rotate0, Rotate90} {
+		fast, _ := newTestDev(t, EPD2in13V2)
+		generic, _ := newTestDev(t, EPD2in13V2)
+		fast.SetRotation(r)
+		generic.SetRotation(r)
+		fast.DrawBuffer(image.Rect(-5, 3, 200, 200), g, image.Pt(10, 0))
+		// Hiding the type takes the generic path.
+		generic.DrawBuffer(image.Rect(-5, 3, 200, 200), struct{ image.Image }{g}, image.Pt(10, 0))
+		if !bytes.Equal(fast.buffer.Pix, generic.buffer.Pix) {
+			t.Errorf("rotation %d: the *image.Gray fast path differs from draw.Draw", r)
+		}
+	}
+}
+
+func BenchmarkDrawGray(b *testing.B) {
+	d := newBenchDev(b)
+	g := grayGradient(122, 250)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.drawBuffer(d.bounds(), g, image.Point{})
+	}
+}
+
+func BenchmarkDrawGrayGeneric(b *testing.B) {
+	d := newBenchDev(b)
+	g := struct{ image.Image }{grayGradient(122, 250)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.drawBuffer(d.bounds(), g, image.Point{})
+	}
+}
+
+func TestDrawNilSource(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	r := d.Bounds()
+	for name, f := range map[string]func() error{
+		"Draw":        func() error { return d.Draw(r, nil, image.Point{}) },
+		"Overlay":     func() error { return d.Overlay(r, nil, image.Point{}) },
+		"DrawPartial": func() error { return d.DrawPartial(r, nil, image.Point{}) },
+		"DrawDiff":    func() error { return d.DrawDiff(r, nil, image.Point{}) },
+		"DrawScaled":  func() error { return d.DrawScaled(nil, Fit) },
+		"DrawGray4":   func() error { return d.DrawGray4(nil) },
+	} {
+		if err := f(); err != errNilSource {
+			t.Errorf("%s: got %v, want %v", name, err, errNilSource)
+		}
+	}
+	if len(c.Ops) != 0 {
+		t.Fatal("something was sent for a nil source")
+	}
+}
+
+func TestDrawEmptySource(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	empty := image1bit.NewVerticalLSB(image.Rectangle{})
+	if err := d.Draw(d.Bounds(), empty, image.Point{}); err == nil {
+		t.Error("expected an error for an empty source")
+	}
+	// An empty destination is a no-op.
+	if err := d.Draw(image.Rectangle{}, empty, image.Point{}); err != nil {
+		t.Error(err)
+	}
+	if err := d.Draw(image.Rect(200, 300, 210, 310), image.Black, image.Point{}); err != nil {
+		t.Error(err)
+	}
+	if len(c.Ops) != 0 {
+		t.Fatal("something was sent for an empty draw")
+	}
+	// A fully transparent source draws white.
+	if err := d.Draw(d.Bounds(), image.Transparent, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, d.Snapshot(), image.Rectangle{})
+}
+
+func TestDrawPartialUnaligned(t *testing.T) {
+	// RAM bytes start at pixels 2, 10, ... with the default data entry mode:
+	// 5-7 is within one byte, 8-10 straddles two.
+	for _, x := range []int{5, 8} {
+		d, c := newTestDev(t, EPD2in13V2)
+		checker := image1bit.NewVerticalLSB(d.Bounds())
+		for y := 0; y < 250; y++ {
+			for x := 0; x < 122; x++ {
+				checker.SetBit(x, y, image1bit.Bit((x+y)%2 == 0))
+			}
+		}
+		if err := d.Draw(d.Bounds(), checker, image.Point{}); err != nil {
+			t.Fatal(err)
+		}
+		c.reset()
+		r := image.Rect(x, 20, x+3, 30)
+		if err := d.DrawPartial(r, image.Black, image.Point{}); err != nil {
+			t.Fatal(err)
+		}
+		draw.Draw(checker, r, image.Black, image.Point{}, draw.Src)
+		// The window is widened to whole RAM bytes, written from the frame
+		// buffer.
+		pr := d.ramRect(r)
+		if pr.Min.X != 2 || pr.Max.X != 10+8*(x/8) {
+			t.Fatalf("x=%d: RAM rectangle %v", x, pr)
+		}
+		want := d.encodeRAM(checker, pr, false)
+		if w := c.find(t, writeRAMBW); len(w) != 1 || !bytes.Equal(w[0].data, want) {
+			t.Fatalf("x=%d: RAM writes %v, want % X", x, w, want)
+		}
+		if !bytes.Equal(d.Image().Pix, checker.Pix) {
+			t.Fatalf("x=%d: pixels outside of %v changed", x, r)
+		}
+	}
+}
+
+func TestDrawPartialKeepsRed(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2, WithTriColor())
+	if err := d.DrawPartial(image.Rect(10, 10, 50, 50), image.Black, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	if w := c.find(t, writeRAMRed); len(w) != 0 {
+		t.Fatalf("a black and white partial update wrote the red RAM: %v", w)
+	}
+	if w := c.find(t, writeRAMBW); len(w) != 1 {
+		t.Fatalf("black and white RAM writes: %v", w)
+	}
+}
+
+func TestRefreshPartialBW(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	if err := d.SetRefreshMode(RefreshPartial); err != nil {
+		t.Fatal(err)
+	}
+	c.reset()
+	d.DrawBuffer(image.Rect(10, 10, 50, 50), image.Black, image.Point{})
+	if err := d.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if w := c.find(t, writeRAMRed); len(w) != 0 {
+		t.Fatalf("a partial refresh wrote the red RAM: %v", w)
+	}
+	if u := c.find(t, displayUpdateControl2); len(u) != 1 || !bytes.Equal(u[0].data, []byte{0x0C}) {
+		t.Fatalf("updates: %v", u)
+	}
+}
+
+func TestInitWindow(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want []command
+	}{
+		{EPD2in13V2, []command{
+			{setRAMXAddressStartEndPosition, []byte{0x00, 0x0F}},
+			{setRAMYAddressStartEndPosition, []byte{0xF9, 0x00, 0x00, 0x00}},
+			{setRAMXAddressCounter, []byte{0x00}},
+			{setRAMYAddressCounter, []byte{0xF9, 0x00}},
+		}},
+		{Config{Width: 128, Height: 296}, []command{
+			{setRAMXAddressStartEndPosition, []byte{0x00, 0x0F}},
+			{setRAMYAddressStartEndPosition, []byte{0x27, 0x01, 0x00, 0x00}},
+			{setRAMXAddressCounter, []byte{0x00}},
+			{setRAMYAddressCounter, []byte{0x27, 0x01}},
+		}},
+	} {
+		d, c := newTestDev(t, tc.cfg)
+		if err := d.Init(); err != nil {
+			t.Fatal(err)
+		}
+		cmds := c.commands(t)
+		if got := cmds[len(cmds)-4:]; !equalCommands(got, tc.want) {
+			t.Errorf("%d lines: Init window %v, want %v", tc.cfg.Height, got, tc.want)
+		}
+		mux := []byte{byte(tc.cfg.Height - 1), byte((tc.cfg.Height - 1) >> 8), 0x00}
+		if got := c.find(t, driverOutputControl); len(got) != 1 || !bytes.Equal(got[0].data, mux) {
+			t.Errorf("%d lines: driver output control %v, want % X", tc.cfg.Height, got, mux)
+		}
+	}
+}
+
+func TestDrawOp(t *testing.T) {
+	// White on the left half, transparent on the right one.
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(src, image.Rect(0, 0, 10, 10), image.White, image.Point{}, draw.Src)
+	for _, tc := range []struct {
+		op draw.Op
+		// right is the color of the transparent right half.
+		right image1bit.Bit
+	}{
+		{draw.Src, image1bit.On},
+		{draw.Over, image1bit.Off},
+	} {
+		d, _ := newTestDev(t, EPD2in13V2)
+		d.DrawBuffer(d.Bounds(), image.Black, image.Point{})
+		if err := d.DrawOp(image.Rect(30, 40, 50, 50), src, image.Point{}, tc.op); err != nil {
+			t.Fatal(err)
+		}
+		img := d.Snapshot()
+		for y := 40; y < 50; y++ {
+			for x := 30; x < 50; x++ {
+				want := image1bit.On
+				if x >= 40 {
+					want = tc.right
+				}
+				if got := img.BitAt(x, y); got != want {
+					t.Fatalf("op %d: pixel (%d, %d) is %v, want %v", tc.op, x, y, got, want)
+				}
+			}
+		}
+		if img.BitAt(29, 40) != image1bit.Off {
+			t.Fatalf("op %d: pixels outside dstRect changed", tc.op)
+		}
+	}
+}
+
+func TestDrawSourceOutOfRange(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	src := image1bit.NewVerticalLSB(image.Rect(0, 0, 50, 50))
+	if err := d.Draw(image.Rect(0, 0, 50, 50), src, image.Pt(50, 0)); err == nil {
+		t.Fatal("expected an error for a source region out of the source")
+	}
+	if err := d.Draw(image.Rect(0, 0, 50, 50), src, image.Pt(-60, -60)); err == nil {
+		t.Fatal("expected an error for a source region out of the source")
+	}
+	if len(c.Ops) != 0 {
+		t.Fatal("something was sent for an invalid source region")
+	}
+	// A partly out of range region is drawn, the rest being white.
+	if err := d.Draw(image.Rect(0, 0, 50, 50), src, image.Pt(25, 0)); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, d.Snapshot(), image.Rect(0, 0, 25, 50))
+}
+
+func TestEncodeImagePolarity(t *testing.T) {
+	d, _ := newTestDev(t, EPD2in13V2)
+	// Pixels 0 to 7 of the first row black, the rest white.
+	img := image1bit.NewVerticalLSB(image.Rect(0, 0, 8, 1))
+	white := bytes.Repeat([]byte{0xFF}, 16)
+	white[15] = 0xC0
+	row0 := append([]byte(nil), white...)
+	row0[14], row0[15] = 0xC0, 0x00
+	want := append(row0, bytes.Repeat(white, 249)...)
+	if got := d.EncodeImage(img, d.Bounds()); !bytes.Equal(got, want) {
+		t.Fatalf("EncodeImage:\n% X\nwant\n% X", got[:32], want[:32])
+	}
+
+	// Inverted, everything but the padding bits is flipped.
+	d.SetInverted(true)
+	for i := range want {
+		want[i] = ^want[i]
+		if i%16 == 15 {
+			want[i] &= 0xC0
+		}
+	}
+	if got := d.EncodeImage(img, d.Bounds()); !bytes.Equal(got, want) {
+		t.Fatalf("inverted EncodeImage:\n% X\nwant\n% X", got[:32], want[:32])
+	}
+}
+
+func TestRAMBit(t *testing.T) {
+	d, _ := newTestDev(t, EPD2in13V2)
+	for _, tc := range []struct {
+		x, y int
+		i    int
+		mask byte
+	}{
+		{0, 0, 15, 0x40},
+		{1, 0, 15, 0x80},
+		{2, 0, 14, 0x01},
+		{121, 0, 0, 0x80},
+		{0, 1, 31, 0x40},
+		{121, 249, 249 * 16, 0x80},
+	} {
+		i, mask, ok := d.RAMBit(tc.x, tc.y)
+		if !ok || i != tc.i || mask != tc.mask {
+			t.Errorf("RAMBit(%d, %d) = %d, %#02x, %t, want %d, %#02x", tc.x, tc.y, i, mask, ok, tc.i, tc.mask)
+		}
+		// The padding bits are never used.
+		if i%16 == 15 && mask&0x3F != 0 {
+			t.Errorf("RAMBit(%d, %d) is a padding bit", tc.x, tc.y)
+		}
+	}
+	if _, _, ok := d.RAMBit(122, 0); ok {
+		t.Error("RAMBit(122, 0) is in bounds")
+	}
+}
+
+func TestSetRAMXWindow(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	if err := d.SetRAMXWindow(0, 15); err != nil {
+		t.Fatal(err)
+	}
+	want := []command{{setRAMXAddressStartEndPosition, []byte{0x00, 0x0F}}, {setRAMXAddressCounter, []byte{0x00}}}
+	if got := c.commands(t); !equalCommands(got, want) {
+		t.Fatalf("SetRAMXWindow sent %v, want %v", got, want)
+	}
+	if err := d.SetRAMXWindow(0, 16); err == nil {
+		t.Fatal("expected an error for byte 16")
+	}
+}
+
+func TestUpdateAfterDraw(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	d.AutoRefresh = false
+	black := image.Rect(0, 0, 50, 50)
+	if err := d.Draw(black, image.Black, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, d.Image(), image.Rectangle{})
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, d.Image(), black)
+
+	// The old image of a differential refresh is the one updated.
+	want := d.encodeRAM(d.buffer, d.buffer.Bounds(), false)
+	c.reset()
+	if err := d.DrawDifferentialPartial(image.Rect(60, 60, 70, 70), image.Black, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	old := c.find(t, writeRAMRed)
+	if len(old) == 0 || !bytes.Equal(old[len(old)-1].data, want) {
+		t.Fatal("the old image of the differential refresh isn't the updated frame")
+	}
+}
+
+func TestLoadPartialModeBase(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	if err := d.Draw(image.Rect(0, 0, 50, 50), image.Black, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	want := d.encodeRAM(d.shown, d.shown.Bounds(), false)
+	// Not yet shown, so not part of the base image.
+	d.DrawBuffer(image.Rect(60, 60, 70, 70), image.Black, image.Point{})
+	c.reset()
+	if err := d.LoadPartialMode(); err != nil {
+		t.Fatal(err)
+	}
+	base := c.find(t, writeRAMRed)
+	if len(base) != 1 || !bytes.Equal(base[0].data, want) {
+		t.Fatalf("base image writes: %d", len(base))
+	}
+
+	// The second RAM is the red plane of tri-color panels.
+	d, c = newTestDev(t, EPD2in13V2, WithTriColor())
+	if err := d.LoadPartialMode(); err != nil {
+		t.Fatal(err)
+	}
+	if w := c.find(t, writeRAMRed); len(w) != 0 {
+		t.Fatalf("the red RAM was written: %v", w)
+	}
+}
+
+func TestSetFullRefreshOption(t *testing.T) {
+	d, c := newTestDev(t, EPD2in13V2)
+	for _, o := range []byte{0xC7, 0xCF, 0xF3} {
+		if err := d.SetFullRefreshOption(o); err == nil {
+			t.Errorf("SetFullRefreshOption(%#02x) succeeded", o)
+		}
+	}
+	if err := d.SetFullRefreshOption(0xD7); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if u := c.find(t, displayUpdateControl2); len(u) != 1 || !bytes.Equal(u[0].data, []byte{0xD7}) {
+		t.Fatalf("updates: %v", u)
+	}
+}